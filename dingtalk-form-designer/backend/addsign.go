@@ -0,0 +1,162 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// Add-signers (加签) are ad-hoc approvers added at runtime to the current
+// node's group. "before" suspends the adding task (WAITING) until every
+// signer has answered; their votes are advice and do not count. "after"
+// approves the adding task and holds the node until every signer approves;
+// a signer's reject fails the node.
+
+// checkSigners validates the requested signers and drops duplicates.
+func (s *Server) checkSigners(task *Task, userID string, ids []string) ([]string, string) {
+	if task.Kind != "" {
+		return nil, "add-signers cannot add further signers"
+	}
+	if len(ids) == 0 {
+		return nil, "signers required"
+	}
+	var out []string
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if id == userID {
+			return nil, "cannot add yourself as a signer"
+		}
+		var active bool
+		if err := s.DB.QueryRow(`SELECT active FROM users WHERE id=?`, id).Scan(&active); err != nil || !active {
+			return nil, "unknown or inactive user: " + id
+		}
+		out = append(out, id)
+	}
+	return out, ""
+}
+
+func createSignTasks(tx *sql.Tx, instID string, parent *Task, mode, kind, actorID, comment string, signers []string, now int64) error {
+	position := "before"
+	if kind == "sign_after" {
+		position = "after"
+	}
+	if err := recordEvent(tx, InstanceEvent{InstanceID: instID, Type: "add_signed", NodeID: parent.NodeID, TaskID: parent.ID, ActorUserID: actorID,
+		Comment: comment, Payload: map[string]any{"position": position, "signers": signers}}, now); err != nil {
+		return err
+	}
+	for _, uid := range signers {
+		id, err := createTask(tx, instID, parent.GroupID, parent.NodeID, mode, Assignee{Type: "user", ID: uid},
+			map[string]any{"addSign": position, "parentTaskId": parent.ID}, now)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE tasks SET kind=?, parent_task_id=? WHERE id=?`, kind, parent.ID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// holdForSigners adjusts the group decision after task acted: a
+// before-signer never decides (the last one resumes the adding task), an
+// after-signer's reject fails the node, and open after-signers hold an
+// approval. A held approval closes the group's remaining votes (an OR
+// sibling could otherwise still reject), so only the signers decide.
+// held reports that nothing else may happen at the node yet.
+func holdForSigners(tx *sql.Tx, task *Task, action string, decision *string, now int64) (held bool, err error) {
+	switch {
+	case task.Kind == "sign_before":
+		*decision = ""
+		var left int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM tasks WHERE parent_task_id=? AND kind='sign_before' AND status='PENDING'`, task.ParentTaskID).Scan(&left); err != nil {
+			return false, err
+		}
+		if left == 0 {
+			if _, err := tx.Exec(`UPDATE tasks SET status='PENDING' WHERE id=? AND status='WAITING'`, task.ParentTaskID); err != nil {
+				return false, err
+			}
+			var instID string
+			if err := tx.QueryRow(`SELECT instance_id FROM tasks WHERE id=?`, task.ParentTaskID).Scan(&instID); err != nil {
+				return false, err
+			}
+			if err := recordEvent(tx, InstanceEvent{InstanceID: instID, Type: "resumed", NodeID: task.NodeID, TaskID: task.ParentTaskID}, now); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case task.Kind == "sign_after" && action == "reject":
+		*decision = "reject"
+		return false, nil
+	case *decision == "reject" || *decision == "return":
+		return false, nil
+	}
+
+	var open int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM tasks WHERE group_id=? AND kind='sign_after' AND status='PENDING'`, task.GroupID).Scan(&open); err != nil {
+		return false, err
+	}
+	if open > 0 {
+		if *decision == "approve" {
+			if err := closeTasksWhere(tx, task.InstanceID, "", now, `group_id=? AND COALESCE(kind,'')<>'sign_after'`, task.GroupID); err != nil {
+				return false, err
+			}
+		}
+		*decision = ""
+		return true, nil
+	}
+	return false, nil
+}
+
+// addSignBefore suspends the task and opens one for each signer.
+func (s *Server) addSignBefore(w http.ResponseWriter, task *Task, inst *Instance, userID string, req ActReq) {
+	signers, msg := s.checkSigners(task, userID, req.Signers)
+	if msg != "" {
+		writeJSON(w, 400, map[string]any{"error": msg})
+		return
+	}
+	if len(req.DataPatch) > 0 {
+		writeJSON(w, 400, map[string]any{"error": "dataPatch not allowed with add_sign_before"})
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().UnixMilli()
+
+	var mode, gStatus string
+	var gVersion int
+	if err := tx.QueryRow(`SELECT mode,status,row_version FROM task_groups WHERE id=?`, task.GroupID).Scan(&mode, &gStatus, &gVersion); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "task group missing"})
+		return
+	}
+	if gStatus != "OPEN" {
+		writeJSON(w, 409, map[string]any{"error": errConflict.Error()})
+		return
+	}
+	if err := execVersioned(tx, `UPDATE tasks SET status='WAITING' WHERE id=? AND status='PENDING'`, task.ID); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if err := execVersioned(tx, `UPDATE task_groups SET row_version=row_version+1 WHERE id=? AND row_version=?`, task.GroupID, gVersion); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if err := createSignTasks(tx, inst.ID, task, mode, "sign_before", userID, req.Comment, signers, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true, "instanceId": inst.ID, "instanceStatus": inst.Status, "currentNode": inst.CurrentNode})
+}
@@ -0,0 +1,74 @@
+package main
+
+import "testing"
+
+// TestAddSignAfterInOrGroup checks that an OR group approved with
+// add_sign_after waits only for the signers: the other assignees' tasks are
+// closed, so none of them can still reject the instance.
+func TestAddSignAfterInOrGroup(t *testing.T) {
+	s, ts := newTestServer(t)
+	publishForm(t, s.DB, map[string]any{
+		"id": "or_form", "name": "OR", "version": 1,
+		"fields": []any{map[string]any{"id": "title", "type": "text", "label": "标题"}},
+		"workflow": map[string]any{
+			"nodes": []any{
+				map[string]any{"id": "start", "name": "发起"},
+				map[string]any{"id": "review", "name": "或签"},
+				map[string]any{"id": "end", "name": "结束"},
+			},
+			"edges": []any{
+				map[string]any{"from": "start", "to": "review", "on": "submit", "mode": "OR", "assignees": []any{
+					map[string]any{"type": "user", "id": "a0"},
+					map[string]any{"type": "user", "id": "a1"},
+				}},
+				map[string]any{"from": "review", "to": "end", "on": "approve", "mode": "OR"},
+			},
+		},
+	})
+
+	var draft struct{ ID string }
+	if code := call(t, ts, "u1", "/api/forms/or_form/instances", map[string]any{"data": map[string]any{"title": "t"}}, &draft); code != 200 {
+		t.Fatalf("create draft: %d", code)
+	}
+	if code := call(t, ts, "u1", "/api/instances/"+draft.ID+"/submit", nil, nil); code != 200 {
+		t.Fatalf("submit: %d", code)
+	}
+	taskOf := func(user string) string {
+		var id string
+		if err := s.DB.QueryRow(`SELECT id FROM tasks WHERE instance_id=? AND assignee_id=? ORDER BY created_at DESC LIMIT 1`, draft.ID, user).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	status := func() string {
+		var st string
+		if err := s.DB.QueryRow(`SELECT status FROM instances WHERE id=?`, draft.ID).Scan(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	if code := call(t, ts, "a0", "/api/tasks/"+taskOf("a0")+"/act", map[string]any{"action": "add_sign_after", "signers": []string{"a2"}}, nil); code != 200 {
+		t.Fatalf("add_sign_after: %d", code)
+	}
+	var siblingStatus string
+	if err := s.DB.QueryRow(`SELECT status FROM tasks WHERE id=?`, taskOf("a1")).Scan(&siblingStatus); err != nil {
+		t.Fatal(err)
+	}
+	if siblingStatus != "DONE" {
+		t.Fatalf("sibling task is %s, want DONE", siblingStatus)
+	}
+	if code := call(t, ts, "a1", "/api/tasks/"+taskOf("a1")+"/act", map[string]any{"action": "reject"}, nil); code == 200 {
+		t.Fatal("sibling could still reject")
+	}
+	if st := status(); st != "RUNNING" {
+		t.Fatalf("instance %s before the signer answered, want RUNNING", st)
+	}
+
+	if code := call(t, ts, "a2", "/api/tasks/"+taskOf("a2")+"/act", map[string]any{"action": "approve"}, nil); code != 200 {
+		t.Fatalf("signer approve: %d", code)
+	}
+	if st := status(); st != "APPROVED" {
+		t.Fatalf("instance %s, want APPROVED", st)
+	}
+}
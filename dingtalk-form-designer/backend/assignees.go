@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// resolveAssignees turns an edge's assignees into the list tasks are created
+// for: applicant becomes the applicant user, role/dept entries with expand
+// become one user per member, field entries become the users picked in the
+// form (for department fields the heads, or with expand the members), and
+// manager-style entries are looked up in the department hierarchy. All of it
+// is snapshotted now. An entry that resolves to nobody uses its fallback;
+// without one it is left out and the first such entry is reported as an
+// *emptyAssigneeError next to the rest. Users listed more than once get a
+// single task.
+func (s *Server) resolveAssignees(tx *sql.Tx, inst *Instance, schema *FormSchema, assignees []Assignee) ([]Assignee, error) {
+	var out []Assignee
+	var empty error
+	seen := map[string]bool{}
+	for _, a := range assignees {
+		list, err := s.resolveWithFallback(tx, inst, schema, a)
+		var ee *emptyAssigneeError
+		if errors.As(err, &ee) {
+			if empty == nil {
+				empty = err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range list {
+			if r.Type == "user" {
+				if seen[r.ID] {
+					continue
+				}
+				seen[r.ID] = true
+			}
+			out = append(out, r)
+		}
+	}
+	return out, empty
+}
+
+// emptyAssigneeError says why an assignee resolved to nobody.
+type emptyAssigneeError struct{ msg string }
+
+func (e *emptyAssigneeError) Error() string { return e.msg }
+
+func (s *Server) resolveWithFallback(tx *sql.Tx, inst *Instance, schema *FormSchema, a Assignee) ([]Assignee, error) {
+	list, err := s.resolveAssignee(tx, inst, schema, a)
+	if err != nil {
+		return nil, err
+	}
+	for fb := a.Fallback; len(list) == 0 && fb != nil; fb = fb.Fallback {
+		if list, err = s.resolveAssignee(tx, inst, schema, *fb); err != nil {
+			return nil, err
+		}
+	}
+	if len(list) == 0 {
+		switch {
+		case a.Type == "manager":
+			return nil, &emptyAssigneeError{fmt.Sprintf("no level %d manager found for the applicant", max(a.Level, 1))}
+		case a.Type == "field" && len(fieldIDs(inst.Data, a.ID)) == 0:
+			f, _ := schema.field(a.ID)
+			return nil, &emptyAssigneeError{fmt.Sprintf("field %q (%s) is empty, cannot pick an approver from it", f.Label, a.ID)}
+		case a.Type == "user":
+			return nil, &emptyAssigneeError{fmt.Sprintf("user %s is unknown or inactive", a.ID)}
+		case a.Type == "role" || a.Type == "dept":
+			return nil, &emptyAssigneeError{fmt.Sprintf("%s %s has no active members", a.Type, a.ID)}
+		}
+		return nil, &emptyAssigneeError{fmt.Sprintf("assignee %s %s resolved to nobody", a.Type, a.ID)}
+	}
+	return list, nil
+}
+
+func (s *Server) resolveAssignee(tx *sql.Tx, inst *Instance, schema *FormSchema, a Assignee) ([]Assignee, error) {
+	// users drops unknown and deactivated users, who could never act
+	users := func(ids []string, err error) ([]Assignee, error) {
+		if err != nil {
+			return nil, err
+		}
+		out := make([]Assignee, 0, len(ids))
+		for _, id := range ids {
+			var active bool
+			err := tx.QueryRow(`SELECT active FROM users WHERE id=?`, id).Scan(&active)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			if active {
+				out = append(out, Assignee{Type: "user", ID: id})
+			}
+		}
+		return out, nil
+	}
+
+	switch a.Type {
+	case "applicant":
+		return users([]string{inst.ApplicantUserID}, nil)
+	case "user":
+		return users([]string{a.ID}, nil)
+	case "role", "dept":
+		// a role or dept without active members would get a task nobody sees
+		members, err := membersOf(tx, a.Type, a.ID)
+		if a.Expand || err != nil || len(members) == 0 {
+			return users(members, err)
+		}
+		return []Assignee{{Type: a.Type, ID: a.ID}}, nil
+
+	case "manager":
+		chain, err := managerChain(tx, inst.ApplicantUserID)
+		if err != nil {
+			return nil, err
+		}
+		level := max(a.Level, 1)
+		if level > len(chain) {
+			return nil, nil
+		}
+		return users(chain[level-1:level], nil)
+
+	case "dept_head":
+		return users(deptHeads(tx, fieldIDs(inst.Data, a.ID)))
+
+	case "field":
+		ids := fieldIDs(inst.Data, a.ID)
+		f, _ := schema.field(a.ID)
+		if f.Type != "department" {
+			return users(ids, nil)
+		}
+		if !a.Expand {
+			return users(deptHeads(tx, ids))
+		}
+		var out []Assignee
+		for _, d := range ids {
+			members, err := users(membersOf(tx, "dept", d))
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, members...)
+		}
+		return out, nil
+
+	case "manager_chain_until":
+		chain, err := managerChain(tx, inst.ApplicantUserID)
+		if err != nil {
+			return nil, err
+		}
+		for i, uid := range chain {
+			var cnt int
+			if err := tx.QueryRow(`SELECT COUNT(1) FROM user_roles WHERE user_id=? AND role_id=?`, uid, a.ID).Scan(&cnt); err != nil {
+				return nil, err
+			}
+			if cnt > 0 {
+				return users(chain[:i+1], nil)
+			}
+		}
+		return nil, nil // nobody up the chain holds the role
+	}
+	return nil, errors.New("invalid assignee type: " + a.Type)
+}
+
+func membersOf(tx *sql.Tx, typ, id string) ([]string, error) {
+	q := `SELECT m.user_id FROM user_roles m JOIN users u ON u.id=m.user_id WHERE m.role_id=? AND u.active=1 ORDER BY m.user_id`
+	if typ == "dept" {
+		q = `SELECT m.user_id FROM user_depts m JOIN users u ON u.id=m.user_id WHERE m.dept_id=? AND u.active=1 ORDER BY m.user_id`
+	}
+	return queryStrings(tx, q, id)
+}
+
+// fieldIDs returns the non-empty ids held by a member or department field.
+func fieldIDs(data map[string]any, fieldID string) []string {
+	list, _ := stringList(data[fieldID])
+	var out []string
+	for _, id := range list {
+		if id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func deptHeads(tx *sql.Tx, depts []string) ([]string, error) {
+	var heads []string
+	for _, d := range depts {
+		var mgr string
+		if err := tx.QueryRow(`SELECT COALESCE(manager_user_id,'') FROM depts WHERE id=?`, d).Scan(&mgr); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if mgr != "" {
+			heads = append(heads, mgr)
+		}
+	}
+	return heads, nil
+}
+
+// managerChain lists the managers above userID, nearest first: the manager
+// of the user's department (the first one by id), then of each parent
+// department. Departments without a manager and the user themself are
+// skipped, so a department head's first-level manager is the parent's head.
+func managerChain(tx *sql.Tx, userID string) ([]string, error) {
+	var dept string
+	err := tx.QueryRow(`SELECT dept_id FROM user_depts WHERE user_id=? ORDER BY dept_id LIMIT 1`, userID).Scan(&dept)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []string
+	seen := map[string]bool{userID: true}
+	visited := map[string]bool{}
+	for dept != "" && !visited[dept] {
+		visited[dept] = true
+		var parent, mgr string
+		err := tx.QueryRow(`SELECT COALESCE(parent_id,''), COALESCE(manager_user_id,'') FROM depts WHERE id=?`, dept).Scan(&parent, &mgr)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if mgr != "" && !seen[mgr] {
+			seen[mgr] = true
+			chain = append(chain, mgr)
+		}
+		dept = parent
+	}
+	return chain, nil
+}
+
+func (schema *FormSchema) field(id string) (Field, bool) {
+	for _, f := range schema.Fields {
+		if f.ID == id {
+			return f, true
+		}
+	}
+	return Field{}, false
+}
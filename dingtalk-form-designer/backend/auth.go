@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Authentication: every /api request (except login/health and form reads)
+// must carry a session, either as the `session` cookie set by
+// /api/auth/login or as `Authorization: Bearer <token>`. Tokens are HS256
+// JWTs signed with AUTH_SECRET. With AUTH_DEV_MODE=1 the
+// X-Impersonate-User header selects the user directly (tests, demo UI).
+
+const (
+	sessionCookie    = "session"
+	impersonateHdr   = "X-Impersonate-User"
+	sessionTTL       = 7 * 24 * time.Hour
+	pbkdf2Iterations = 100_000
+)
+
+type ctxKey string
+
+const userCtxKey ctxKey = "userId"
+
+// currentUserID returns the authenticated user; "" outside RequireUser.
+func currentUserID(r *http.Request) string {
+	uid, _ := r.Context().Value(userCtxKey).(string)
+	return uid
+}
+
+// Authenticate resolves the current user (if any) into the request context.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid := ""
+		if s.DevMode && r.Header.Get(impersonateHdr) != "" {
+			uid = r.Header.Get(impersonateHdr)
+		} else if tok := requestToken(r); tok != "" {
+			if sub, err := s.verifyToken(tok); err == nil {
+				uid = sub
+			}
+		}
+		// deactivated users lose their sessions too
+		if uid != "" {
+			var cnt int
+			if err := s.DB.QueryRow(`SELECT COUNT(1) FROM users WHERE id=? AND active=1`, uid).Scan(&cnt); err != nil || cnt == 0 {
+				uid = ""
+			}
+		}
+		if uid != "" {
+			r = r.WithContext(context.WithValue(r.Context(), userCtxKey, uid))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireUser rejects requests without an authenticated user.
+func RequireUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if currentUserID(r) == "" {
+			writeJSON(w, 401, map[string]any{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminRole is the role allowed to manage the organization.
+const adminRole = "admin"
+
+func (s *Server) isAdmin(userID string) bool {
+	return s.exists(`SELECT COUNT(1) FROM user_roles WHERE user_id=? AND role_id=?`, userID, adminRole)
+}
+
+// RequireAdmin rejects requests from users without the admin role.
+func (s *Server) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.isAdmin(currentUserID(r)) {
+			writeJSON(w, 403, map[string]any{"error": "admin only"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(h[len("Bearer "):])
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+/* ---------------- handlers ---------------- */
+
+type LoginReq struct {
+	UserID   string `json:"userId"`
+	Password string `json:"password"`
+}
+
+func (s *Server) Login(w http.ResponseWriter, r *http.Request) {
+	var req LoginReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	var name, hash string
+	err := s.DB.QueryRow(`SELECT name, COALESCE(password_hash,'') FROM users WHERE id=? AND active=1`, req.UserID).Scan(&name, &hash)
+	if err != nil || hash == "" || !checkPassword(hash, req.Password) {
+		writeJSON(w, 401, map[string]any{"error": "invalid credentials"})
+		return
+	}
+
+	exp := time.Now().Add(sessionTTL)
+	tok := s.signToken(req.UserID, exp)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    tok,
+		Path:     "/",
+		Expires:  exp,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	writeJSON(w, 200, map[string]any{"token": tok, "expiresAt": exp.UnixMilli(), "user": map[string]any{"id": req.UserID, "name": name}})
+}
+
+func (s *Server) Logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true})
+	writeJSON(w, 200, map[string]any{"ok": true})
+}
+
+func (s *Server) Me(w http.ResponseWriter, r *http.Request) {
+	uid := currentUserID(r)
+	var name string
+	_ = s.DB.QueryRow(`SELECT name FROM users WHERE id=?`, uid).Scan(&name)
+	roles, err := queryStrings(s.DB, `SELECT role_id FROM user_roles WHERE user_id=? ORDER BY role_id`, uid)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	depts, err := queryStrings(s.DB, `SELECT dept_id FROM user_depts WHERE user_id=? ORDER BY dept_id`, uid)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"id": uid, "name": name, "roles": roles, "depts": depts})
+}
+
+/* ---------------- tokens (HS256 JWT) ---------------- */
+
+var b64 = base64.RawURLEncoding
+
+func (s *Server) signToken(userID string, exp time.Time) string {
+	header := b64.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims, _ := json.Marshal(map[string]any{"sub": userID, "iat": time.Now().Unix(), "exp": exp.Unix()})
+	unsigned := header + "." + b64.EncodeToString(claims)
+	return unsigned + "." + b64.EncodeToString(s.mac(unsigned))
+}
+
+func (s *Server) verifyToken(tok string) (string, error) {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed token")
+	}
+	sig, err := b64.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, s.mac(parts[0]+"."+parts[1])) {
+		return "", errors.New("bad signature")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	hb, _ := b64.DecodeString(parts[0])
+	if err := json.Unmarshal(hb, &header); err != nil || header.Alg != "HS256" {
+		return "", errors.New("unsupported alg")
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+		Exp int64  `json:"exp"`
+	}
+	cb, _ := b64.DecodeString(parts[1])
+	if err := json.Unmarshal(cb, &claims); err != nil {
+		return "", errors.New("bad claims")
+	}
+	if claims.Exp != 0 && time.Now().Unix() >= claims.Exp {
+		return "", errors.New("token expired")
+	}
+	return claims.Sub, nil
+}
+
+func (s *Server) mac(msg string) []byte {
+	m := hmac.New(sha256.New, s.AuthSecret)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+/* ---------------- passwords (PBKDF2-HMAC-SHA256) ---------------- */
+
+// hashPassword returns "pbkdf2_sha256$<iterations>$<salt>$<hash>".
+func hashPassword(pw string) string {
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+	key := pbkdf2SHA256([]byte(pw), salt, pbkdf2Iterations, 32)
+	return fmt.Sprintf("pbkdf2_sha256$%d$%s$%s", pbkdf2Iterations, b64.EncodeToString(salt), b64.EncodeToString(key))
+}
+
+func checkPassword(encoded, pw string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2_sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil || iter <= 0 {
+		return false
+	}
+	salt, err1 := b64.DecodeString(parts[2])
+	want, err2 := b64.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got := pbkdf2SHA256([]byte(pw), salt, iter, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func pbkdf2SHA256(pw, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, pw)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var idx [4]byte
+		binary.BigEndian.PutUint32(idx[:], block)
+		prf.Write(idx[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
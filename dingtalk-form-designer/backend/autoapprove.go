@@ -0,0 +1,141 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// autoApprove applies the workflow's autoApprove rule to a group that was
+// just created: tasks of the applicant, or of someone who already approved
+// the previous node (adjacent) or any node since the last submit (any), are
+// closed as auto_approved and count as approvals. A role or dept task goes
+// when one of those users is a member. SEQ groups keep going down the queue.
+// It reports whether the group has passed.
+func (s *Server) autoApprove(tx *sql.Tx, inst *Instance, schema *FormSchema, groupID, nodeID string, now int64) (bool, error) {
+	rule := schema.Workflow.AutoApprove
+	if rule == nil || nodeID == "start" {
+		return false, nil
+	}
+	prior, err := priorApprovers(tx, inst.ID, groupID, rule.Repeated)
+	if err != nil {
+		return false, err
+	}
+
+	var mode, queueJSON string
+	var total, approved, rejected, approveAt, rejectAt int
+	if err := tx.QueryRow(`SELECT mode,total_count,approved_count,rejected_count,approve_threshold,reject_threshold,COALESCE(assignees_json,'')
+		FROM task_groups WHERE id=?`, groupID).
+		Scan(&mode, &total, &approved, &rejected, &approveAt, &rejectAt, &queueJSON); err != nil {
+		return false, err
+	}
+
+	// who, if anyone, has already approved in place of the assignee
+	approver := func(typ, id string) (string, string, error) {
+		if rule.Applicant {
+			ok, err := userMatchesAssignee(tx, inst.ApplicantUserID, typ, id)
+			if ok || err != nil {
+				return inst.ApplicantUserID, "applicant", err
+			}
+		}
+		for _, uid := range prior {
+			ok, err := userMatchesAssignee(tx, uid, typ, id)
+			if ok || err != nil {
+				return uid, "repeated", err
+			}
+		}
+		return "", "", nil
+	}
+
+	decision := ""
+	for {
+		type pending struct{ id, typ, assignee string }
+		var open []pending
+		rows, err := tx.Query(`SELECT id, assignee_type, assignee_id FROM tasks WHERE group_id=? AND status='PENDING' ORDER BY created_at, rowid`, groupID)
+		if err != nil {
+			return false, err
+		}
+		for rows.Next() {
+			var p pending
+			if err := rows.Scan(&p.id, &p.typ, &p.assignee); err != nil {
+				rows.Close()
+				return false, err
+			}
+			open = append(open, p)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return false, err
+		}
+
+		closed := 0
+		for _, p := range open {
+			uid, reason, err := approver(p.typ, p.assignee)
+			if err != nil {
+				return false, err
+			}
+			if uid == "" {
+				continue
+			}
+			if _, err := tx.Exec(`UPDATE tasks SET status='DONE', action_taken='auto_approved', actor_user_id=?, completed_at=? WHERE id=?`,
+				uid, now, p.id); err != nil {
+				return false, err
+			}
+			if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "auto_approved", NodeID: nodeID, TaskID: p.id, ActorUserID: uid,
+				Payload: map[string]any{"reason": reason}}, now); err != nil {
+				return false, err
+			}
+			approved++
+			closed++
+		}
+		decision = groupDecision(approved, rejected, total, approveAt, rejectAt)
+		if closed == 0 || decision != "" || mode != "SEQ" {
+			break
+		}
+
+		// SEQ: the next assignee in line may be redundant too
+		var queue []Assignee
+		next := approved + rejected
+		if err := json.Unmarshal([]byte(queueJSON), &queue); err != nil || next >= len(queue) {
+			break
+		}
+		if err := createSeqTask(tx, inst.ID, groupID, nodeID, queue, next, now); err != nil {
+			return false, err
+		}
+	}
+
+	if _, err := tx.Exec(`UPDATE task_groups SET approved_count=?, row_version=row_version+1 WHERE id=?`, approved, groupID); err != nil {
+		return false, err
+	}
+	return decision == "approve", nil
+}
+
+// priorApprovers lists who approved since the last submit: at any node, or
+// (adjacent) in the group closed last, the node the instance just left.
+func priorApprovers(tx *sql.Tx, instID, groupID, repeated string) ([]string, error) {
+	if repeated == "" {
+		return nil, nil
+	}
+	var since int64
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(created_at),0) FROM instance_events WHERE instance_id=? AND type='submitted'`, instID).
+		Scan(&since); err != nil {
+		return nil, err
+	}
+	if repeated == "adjacent" {
+		var last string
+		err := tx.QueryRow(`SELECT id FROM task_groups WHERE instance_id=? AND id<>? AND node_id<>'start' AND status='CLOSED' AND closed_at>=?
+			ORDER BY closed_at DESC, rowid DESC LIMIT 1`, instID, groupID, since).Scan(&last)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return queryStrings(tx, `SELECT DISTINCT actor_user_id FROM tasks
+			WHERE group_id=? AND status='DONE' AND action_taken IN ('approve','auto_approved') AND actor_user_id IS NOT NULL
+			ORDER BY completed_at`, last)
+	}
+	return queryStrings(tx, `SELECT DISTINCT actor_user_id FROM tasks
+		WHERE instance_id=? AND group_id<>? AND node_id<>'start' AND status='DONE' AND action_taken IN ('approve','auto_approved')
+		  AND actor_user_id IS NOT NULL AND completed_at>=?
+		ORDER BY completed_at`, instID, groupID, since)
+}
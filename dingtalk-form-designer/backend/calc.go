@@ -0,0 +1,641 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Calculation expressions (FormSchema.Calculations[].Expr).
+//
+// Grammar (lowest to highest precedence):
+//
+//	expr    := or
+//	or      := and ("||" and)*
+//	and     := cmp ("&&" cmp)*
+//	cmp     := add (("=="|"!="|">"|">="|"<"|"<=") add)?
+//	add     := mul (("+"|"-") mul)*
+//	mul     := unary (("*"|"/"|"%") unary)*
+//	unary   := ("-"|"!") unary | primary
+//	primary := number | string | true | false | path | call | "(" expr ")"
+//	path    := ident ("." ident)*          e.g. days, items.cost
+//	call    := ident "(" [expr ("," expr)*] ")"
+//
+// Functions: sum, avg, min, max, count, round, abs, if, datediff.
+// Aggregates evaluate their argument once per subtable row when it
+// references a subtable column (sum(items.qty * items.price)), otherwise
+// over the argument list (max(a, b, 0)).
+
+type calcNode interface {
+	eval(env *calcEnv) (any, error)
+}
+
+type calcEnv struct {
+	data  map[string]any
+	table string         // subtable being iterated by an aggregate
+	row   map[string]any // current row of table
+}
+
+/* ---------------- evaluation entry points ---------------- */
+
+func compileCalc(expr string) (calcNode, error) {
+	p := &calcParser{src: expr}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+	n, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.toks) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.toks[p.pos].text, p.toks[p.pos].at)
+	}
+	return n, nil
+}
+
+// applyCalculations recomputes every calculated field in data, in schema order.
+func applyCalculations(schema *FormSchema, data map[string]any) error {
+	for _, c := range schema.Calculations {
+		n, err := compileCalc(c.Expr)
+		if err != nil {
+			return fmt.Errorf("calculation %s: %w", c.TargetFieldId, err)
+		}
+		v, err := n.eval(&calcEnv{data: data})
+		if err != nil {
+			return fmt.Errorf("calculation %s: %w", c.TargetFieldId, err)
+		}
+		if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			v = nil
+		}
+		data[c.TargetFieldId] = v
+	}
+	return nil
+}
+
+// rejectCalculatedWrites refuses client-provided values for calculated fields.
+func rejectCalculatedWrites(schema *FormSchema, patch map[string]any) error {
+	for _, c := range schema.Calculations {
+		if _, ok := patch[c.TargetFieldId]; ok {
+			return errors.New("field is calculated and cannot be written: " + c.TargetFieldId)
+		}
+	}
+	return nil
+}
+
+/* ---------------- tokenizer ---------------- */
+
+type calcTok struct {
+	kind string // num|str|ident|op
+	text string
+	at   int
+}
+
+type calcParser struct {
+	src  string
+	toks []calcTok
+	pos  int
+}
+
+func (p *calcParser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			p.toks = append(p.toks, calcTok{"num", s[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				j++
+			}
+			if j >= len(s) {
+				return fmt.Errorf("unterminated string at %d", i)
+			}
+			p.toks = append(p.toks, calcTok{"str", s[i+1 : j], i})
+			i = j + 1
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			p.toks = append(p.toks, calcTok{"ident", s[i:j], i})
+			i = j
+		default:
+			if i+1 < len(s) {
+				two := s[i : i+2]
+				switch two {
+				case "==", "!=", ">=", "<=", "&&", "||":
+					p.toks = append(p.toks, calcTok{"op", two, i})
+					i += 2
+					continue
+				}
+			}
+			if strings.IndexByte("+-*/%()<>!,.", c) < 0 {
+				return fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			p.toks = append(p.toks, calcTok{"op", string(c), i})
+			i++
+		}
+	}
+	return nil
+}
+
+/* ---------------- parser ---------------- */
+
+func (p *calcParser) peekOp(ops ...string) (string, bool) {
+	if p.pos >= len(p.toks) || p.toks[p.pos].kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if p.toks[p.pos].text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *calcParser) expectOp(op string) error {
+	if _, ok := p.peekOp(op); !ok {
+		if p.pos >= len(p.toks) {
+			return fmt.Errorf("expected %q at end of expression", op)
+		}
+		return fmt.Errorf("expected %q at %d", op, p.toks[p.pos].at)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *calcParser) parseBinary(next func() (calcNode, error), ops ...string) (calcNode, error) {
+	left, err := next()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.peekOp(ops...)
+		if !ok {
+			return left, nil
+		}
+		p.pos++
+		right, err := next()
+		if err != nil {
+			return nil, err
+		}
+		left = &calcBinary{op: op, l: left, r: right}
+	}
+}
+
+func (p *calcParser) parseOr() (calcNode, error)  { return p.parseBinary(p.parseAnd, "||") }
+func (p *calcParser) parseAnd() (calcNode, error) { return p.parseBinary(p.parseCmp, "&&") }
+func (p *calcParser) parseAdd() (calcNode, error) { return p.parseBinary(p.parseMul, "+", "-") }
+func (p *calcParser) parseMul() (calcNode, error) { return p.parseBinary(p.parseUnary, "*", "/", "%") }
+
+func (p *calcParser) parseCmp() (calcNode, error) {
+	left, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.peekOp("==", "!=", ">=", "<=", ">", "<")
+	if !ok {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	return &calcBinary{op: op, l: left, r: right}, nil
+}
+
+func (p *calcParser) parseUnary() (calcNode, error) {
+	if op, ok := p.peekOp("-", "!"); ok {
+		p.pos++
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &calcUnary{op: op, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *calcParser) parsePrimary() (calcNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case "num":
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q at %d", t.text, t.at)
+		}
+		return &calcLit{v: f}, nil
+	case "str":
+		return &calcLit{v: t.text}, nil
+	case "ident":
+		switch t.text {
+		case "true":
+			return &calcLit{v: true}, nil
+		case "false":
+			return &calcLit{v: false}, nil
+		case "null":
+			return &calcLit{v: nil}, nil
+		}
+		if _, ok := p.peekOp("("); ok {
+			p.pos++
+			call := &calcCall{name: strings.ToLower(t.text)}
+			if _, ok := p.peekOp(")"); !ok {
+				for {
+					arg, err := p.parseOr()
+					if err != nil {
+						return nil, err
+					}
+					call.args = append(call.args, arg)
+					if _, ok := p.peekOp(","); !ok {
+						break
+					}
+					p.pos++
+				}
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			if err := call.check(); err != nil {
+				return nil, fmt.Errorf("%s at %d", err.Error(), t.at)
+			}
+			return call, nil
+		}
+		path := []string{t.text}
+		for {
+			if _, ok := p.peekOp("."); !ok {
+				break
+			}
+			p.pos++
+			if p.pos >= len(p.toks) || p.toks[p.pos].kind != "ident" {
+				return nil, fmt.Errorf("expected field name after '.' at %d", t.at)
+			}
+			path = append(path, p.toks[p.pos].text)
+			p.pos++
+		}
+		return &calcPath{path: path}, nil
+	case "op":
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.at)
+}
+
+/* ---------------- AST ---------------- */
+
+type calcLit struct{ v any }
+
+func (n *calcLit) eval(*calcEnv) (any, error) { return n.v, nil }
+
+type calcPath struct{ path []string }
+
+func (n *calcPath) eval(env *calcEnv) (any, error) {
+	var cur any = env.data
+	rest := n.path
+	if env.row != nil && n.path[0] == env.table && len(n.path) > 1 {
+		cur = env.row
+		rest = n.path[1:]
+	}
+	for _, seg := range rest {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, nil
+		}
+		cur = m[seg]
+	}
+	return cur, nil
+}
+
+type calcUnary struct {
+	op string
+	x  calcNode
+}
+
+func (n *calcUnary) eval(env *calcEnv) (any, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !calcTruthy(v), nil
+	}
+	return -toFloat(v), nil
+}
+
+type calcBinary struct {
+	op   string
+	l, r calcNode
+}
+
+func (n *calcBinary) eval(env *calcEnv) (any, error) {
+	a, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&":
+		if !calcTruthy(a) {
+			return false, nil
+		}
+		b, err := n.r.eval(env)
+		return calcTruthy(b), err
+	case "||":
+		if calcTruthy(a) {
+			return true, nil
+		}
+		b, err := n.r.eval(env)
+		return calcTruthy(b), err
+	}
+	b, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "+":
+		_, as := a.(string)
+		_, bs := b.(string)
+		if as || bs {
+			return stringify(a) + stringify(b), nil
+		}
+		return toFloat(a) + toFloat(b), nil
+	case "-":
+		return toFloat(a) - toFloat(b), nil
+	case "*":
+		return toFloat(a) * toFloat(b), nil
+	case "/":
+		if toFloat(b) == 0 {
+			return nil, nil
+		}
+		return toFloat(a) / toFloat(b), nil
+	case "%":
+		if toFloat(b) == 0 {
+			return nil, nil
+		}
+		return math.Mod(toFloat(a), toFloat(b)), nil
+	case "==":
+		return looseEqual(a, b), nil
+	case "!=":
+		return !looseEqual(a, b), nil
+	case ">":
+		return toFloat(a) > toFloat(b), nil
+	case ">=":
+		return toFloat(a) >= toFloat(b), nil
+	case "<":
+		return toFloat(a) < toFloat(b), nil
+	case "<=":
+		return toFloat(a) <= toFloat(b), nil
+	}
+	return nil, errors.New("unknown operator " + n.op)
+}
+
+type calcCall struct {
+	name string
+	args []calcNode
+}
+
+var calcArity = map[string][2]int{ // min, max (-1 = unbounded)
+	"sum":      {1, -1},
+	"avg":      {1, -1},
+	"min":      {1, -1},
+	"max":      {1, -1},
+	"count":    {1, 1},
+	"round":    {1, 2},
+	"abs":      {1, 1},
+	"if":       {3, 3},
+	"datediff": {2, 3},
+}
+
+func (n *calcCall) check() error {
+	ar, ok := calcArity[n.name]
+	if !ok {
+		return errors.New("unknown function " + n.name)
+	}
+	if len(n.args) < ar[0] || (ar[1] >= 0 && len(n.args) > ar[1]) {
+		return fmt.Errorf("wrong number of arguments to %s", n.name)
+	}
+	return nil
+}
+
+func (n *calcCall) eval(env *calcEnv) (any, error) {
+	switch n.name {
+	case "sum", "avg", "min", "max", "count":
+		return n.aggregate(env)
+	case "round":
+		x, err := n.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		digits := 0.0
+		if len(n.args) == 2 {
+			d, err := n.args[1].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			digits = toFloat(d)
+		}
+		p := math.Pow(10, digits)
+		return math.Round(toFloat(x)*p) / p, nil
+	case "abs":
+		x, err := n.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		return math.Abs(toFloat(x)), nil
+	case "if":
+		c, err := n.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if calcTruthy(c) {
+			return n.args[1].eval(env)
+		}
+		return n.args[2].eval(env)
+	case "datediff":
+		a, err := n.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		b, err := n.args[1].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		unit := "days"
+		if len(n.args) == 3 {
+			u, err := n.args[2].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			unit = stringify(u)
+		}
+		ta, okA := parseCalcTime(a)
+		tb, okB := parseCalcTime(b)
+		if !okA || !okB {
+			return nil, nil
+		}
+		d := tb.Sub(ta)
+		switch unit {
+		case "days":
+			return math.Floor(d.Hours() / 24), nil
+		case "hours":
+			return math.Floor(d.Hours()), nil
+		case "minutes":
+			return math.Floor(d.Minutes()), nil
+		default:
+			return nil, errors.New("datediff: unknown unit " + unit)
+		}
+	}
+	return nil, errors.New("unknown function " + n.name)
+}
+
+// aggregate collects values either per subtable row (single argument that
+// references a subtable column) or from the argument list.
+func (n *calcCall) aggregate(env *calcEnv) (any, error) {
+	var vals []any
+	if table := n.rowTable(env); table != "" {
+		rows, _ := env.data[table].([]any)
+		for _, r := range rows {
+			row, _ := r.(map[string]any)
+			v, err := n.args[0].eval(&calcEnv{data: env.data, table: table, row: row})
+			if err != nil {
+				return nil, err
+			}
+			vals = append(vals, v)
+		}
+	} else {
+		for _, a := range n.args {
+			v, err := a.eval(env)
+			if err != nil {
+				return nil, err
+			}
+			if arr, ok := v.([]any); ok {
+				vals = append(vals, arr...)
+			} else {
+				vals = append(vals, v)
+			}
+		}
+	}
+
+	var nums []float64
+	for _, v := range vals {
+		if v == nil || isEmptyString(v) {
+			continue
+		}
+		nums = append(nums, toFloat(v))
+	}
+	switch n.name {
+	case "count":
+		return float64(len(nums)), nil
+	case "sum":
+		total := 0.0
+		for _, x := range nums {
+			total += x
+		}
+		return total, nil
+	case "avg":
+		if len(nums) == 0 {
+			return nil, nil
+		}
+		total := 0.0
+		for _, x := range nums {
+			total += x
+		}
+		return total / float64(len(nums)), nil
+	case "min", "max":
+		if len(nums) == 0 {
+			return nil, nil
+		}
+		best := nums[0]
+		for _, x := range nums[1:] {
+			if (n.name == "min" && x < best) || (n.name == "max" && x > best) {
+				best = x
+			}
+		}
+		return best, nil
+	}
+	return nil, nil
+}
+
+// rowTable returns the subtable a single-argument aggregate iterates over:
+// the first one whose column it references. A bare table or array
+// (count(items)) is an argument list, not a per-row expression.
+func (n *calcCall) rowTable(env *calcEnv) string {
+	if len(n.args) != 1 || env.row != nil {
+		return ""
+	}
+	var found string
+	var walk func(x calcNode)
+	walk = func(x calcNode) {
+		switch t := x.(type) {
+		case *calcPath:
+			if found == "" && len(t.path) > 1 {
+				if _, ok := env.data[t.path[0]].([]any); ok {
+					found = t.path[0]
+				}
+			}
+		case *calcUnary:
+			walk(t.x)
+		case *calcBinary:
+			walk(t.l)
+			walk(t.r)
+		case *calcCall:
+			for _, a := range t.args {
+				walk(a)
+			}
+		}
+	}
+	walk(n.args[0])
+	return found
+}
+
+/* ---------------- helpers ---------------- */
+
+func calcTruthy(v any) bool {
+	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case string:
+		return t != ""
+	case float64:
+		return t != 0
+	}
+	return toFloat(v) != 0
+}
+
+func parseCalcTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case float64:
+		return time.UnixMilli(int64(t)), true
+	case string:
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+			if tm, err := time.ParseInLocation(layout, t, time.Local); err == nil {
+				return tm, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
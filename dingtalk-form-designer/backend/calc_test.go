@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCalcEval(t *testing.T) {
+	const items = `{"items":[{"qty":2,"price":3},{"qty":1,"price":4},{"qty":"","price":5}],"nums":[1,2,3],"days":2,"a":{"b":7}}`
+	cases := []struct {
+		expr, data, want string
+	}{
+		// literals, paths, precedence
+		{"1 + 2 * 3", `{}`, `7`},
+		{"(1 + 2) * 3", `{}`, `9`},
+		{"10 - 2 - 3", `{}`, `5`},
+		{"-2 * 3", `{}`, `-6`},
+		{"2 * 3 % 4", `{}`, `2`},
+		{"1 + 2 == 3", `{}`, `true`},
+		{"1 < 2 && 2 < 1 || 3 > 2", `{}`, `true`},
+		{"!0 && !''", `{}`, `true`},
+		{"'a' + 1", `{}`, `"a1"`},
+		{"days * 8", items, `16`},
+		{"a.b + 1", items, `8`},
+		{"missing.field", items, `null`},
+
+		// functions
+		{"round(1.2345, 2)", `{}`, `1.23`},
+		{"round(2.5)", `{}`, `3`},
+		{"abs(-3)", `{}`, `3`},
+		{"if(days > 1, 'long', 'short')", items, `"long"`},
+		{"max(days, 5, 0)", items, `5`},
+		{"datediff('2024-01-01', '2024-01-31')", `{}`, `30`},
+		{"datediff('2024-01-01 08:00', '2024-01-01 17:30', 'hours')", `{}`, `9`},
+
+		// subtable aggregates: once per row when a column is referenced
+		{"sum(items.qty * items.price)", items, `10`},
+		{"sum(items.price)", items, `12`},
+		{"avg(items.qty)", items, `1.5`},
+		{"count(items.qty)", items, `2`},
+		{"max(items.price) - min(items.price)", items, `2`},
+		{"sum(items.qty * days)", items, `6`},
+		{"min(empty.x)", `{"empty":[]}`, `null`},
+
+		// a bare array or table is an argument list
+		{"sum(nums)", items, `6`},
+		{"count(items)", items, `3`},
+		{"max(nums, 10)", items, `10`},
+
+		// division by zero yields no value
+		{"1 / 0", `{}`, `null`},
+		{"5 % 0", `{}`, `null`},
+		{"days / missing", items, `null`},
+		{"sum(items.price / items.qty)", items, `5.5`},
+	}
+	for _, c := range cases {
+		n, err := compileCalc(c.expr)
+		if err != nil {
+			t.Errorf("%s: %v", c.expr, err)
+			continue
+		}
+		var data map[string]any
+		if err := json.Unmarshal([]byte(c.data), &data); err != nil {
+			t.Fatal(err)
+		}
+		got, err := n.eval(&calcEnv{data: data})
+		if err != nil {
+			t.Errorf("%s with %s: %v", c.expr, c.data, err)
+			continue
+		}
+		if jsonText(got) != c.want {
+			t.Errorf("%s with %s = %s, want %s", c.expr, c.data, jsonText(got), c.want)
+		}
+	}
+}
+
+func TestCalcCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"a.",
+		"'open",
+		"1 # 2",
+		"foo(1)",
+		"round()",
+		"if(1, 2)",
+	} {
+		if _, err := compileCalc(expr); err == nil {
+			t.Errorf("%q compiled, want an error", expr)
+		}
+	}
+}
+
+func TestApplyCalculations(t *testing.T) {
+	schema := &FormSchema{Calculations: []Calc{
+		{TargetFieldId: "total", Expr: "sum(items.qty * items.price)"},
+		{TargetFieldId: "perItem", Expr: "total / count(items)"},
+	}}
+	data := map[string]any{"items": []any{}}
+	if err := applyCalculations(schema, data); err != nil {
+		t.Fatal(err)
+	}
+	// later calculations see earlier results; an empty table divides by zero
+	if data["total"] != 0.0 || data["perItem"] != nil {
+		t.Fatalf("got total=%v perItem=%v, want 0 and nil", data["total"], data["perItem"])
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Optimistic concurrency: instances and task_groups carry a row_version that
+// every write bumps. Writes are conditioned on the version the handler read,
+// so a concurrent change makes the statement match no row and the request
+// fails with 409 instead of silently overwriting.
+
+var errConflict = errors.New("conflict: modified concurrently, reload and retry")
+
+// execVersioned runs a conditional UPDATE and returns errConflict when it
+// matched no row.
+func execVersioned(x execer, q string, args ...any) error {
+	res, err := x.Exec(q, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errConflict
+	}
+	return nil
+}
+
+// reloadInstance re-reads the mutable columns of inst inside tx. Approvals
+// in one AND group or in parallel branches are legitimately concurrent: the
+// transaction serializes them, and each one has to build on the instance as
+// the previous one left it rather than on the row read before it waited.
+func reloadInstance(tx *sql.Tx, inst *Instance) error {
+	var dataJSON string
+	if err := tx.QueryRow(`SELECT status,current_node,data_json,submit_count,row_version FROM instances WHERE id=?`, inst.ID).
+		Scan(&inst.Status, &inst.CurrentNode, &dataJSON, &inst.SubmitCount, &inst.RowVersion); err != nil {
+		return err
+	}
+	inst.Data = map[string]any{}
+	return json.Unmarshal([]byte(dataJSON), &inst.Data)
+}
+
+// writeTxError reports errConflict as 409 and anything else as 500.
+func writeTxError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errConflict) {
+		writeJSON(w, 409, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 500, map[string]any{"error": err.Error()})
+}
+
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatch reports whether the request's If-Match header (if any) accepts
+// the given row version.
+func ifMatch(r *http.Request, version int) bool {
+	h := r.Header.Get("If-Match")
+	if h == "" {
+		return true
+	}
+	for _, t := range strings.Split(h, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag(version) {
+			return true
+		}
+	}
+	return false
+}
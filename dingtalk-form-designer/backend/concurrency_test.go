@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	_ "modernc.org/sqlite"
+)
+
+const andApprovers = 8
+
+// newTestServer serves the instance and task endpoints over a fresh database
+// with andApprovers users a0..a7 and a published form whose only node is an
+// AND group of all of them.
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	db, err := sql.Open("sqlite", sqliteDSN(filepath.Join(t.TempDir(), "test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := seedDemo(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var assignees []any
+	for i := 0; i < andApprovers; i++ {
+		id := fmt.Sprintf("a%d", i)
+		if _, err := db.Exec(`INSERT INTO users(id,name) VALUES (?,?)`, id, id); err != nil {
+			t.Fatal(err)
+		}
+		assignees = append(assignees, map[string]any{"type": "user", "id": id})
+	}
+	schema := map[string]any{
+		"id": "and_form", "name": "AND", "version": 1,
+		"fields": []any{
+			map[string]any{"id": "title", "type": "text", "label": "标题", "required": true},
+			map[string]any{"id": "note", "type": "text", "label": "意见"},
+		},
+		"workflow": map[string]any{
+			"nodes": []any{
+				map[string]any{"id": "start", "name": "发起"},
+				map[string]any{"id": "review", "name": "会签"},
+				map[string]any{"id": "end", "name": "结束"},
+			},
+			"edges": []any{
+				map[string]any{"from": "start", "to": "review", "on": "submit", "mode": "AND", "assignees": assignees},
+				map[string]any{"from": "review", "to": "end", "on": "approve", "mode": "OR"},
+			},
+			"policies": map[string]any{
+				"start":  map[string]any{"visible": []string{"*"}, "editable": []string{"*"}, "required": []string{"title"}},
+				"review": map[string]any{"visible": []string{"*"}, "editable": []string{"note"}, "required": []string{}},
+			},
+		},
+	}
+	publishForm(t, db, schema)
+
+	s := &Server{DB: db, AuthSecret: []byte("test"), DevMode: true}
+	r := chi.NewRouter()
+	r.Use(s.Authenticate)
+	r.Use(RequireUser)
+	r.Post("/api/forms/{id}/instances", s.CreateInstanceDraft)
+	r.Post("/api/instances/{id}/submit", s.SubmitInstance)
+	r.Post("/api/tasks/{id}/act", s.ActOnTask)
+	ts := httptest.NewServer(r)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// publishForm stores schema as the published version 1 of its form.
+func publishForm(t *testing.T, db *sql.DB, schema map[string]any) {
+	t.Helper()
+	b, _ := json.Marshal(schema)
+	if _, err := db.Exec(`INSERT INTO forms(id,version,name,status,schema_json,updated_at) VALUES (?,1,?,'published',?,?)`,
+		schema["id"], schema["name"], string(b), time.Now().UnixMilli()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// call posts body as user and decodes the JSON reply into out (if not nil).
+func call(t *testing.T, ts *httptest.Server, user, path string, body any, out any) int {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", ts.URL+path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(impersonateHdr, user)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Error(err)
+		return 0
+	}
+	defer res.Body.Close()
+	if out != nil {
+		_ = json.NewDecoder(res.Body).Decode(out)
+	}
+	return res.StatusCode
+}
+
+func TestConcurrentApprovalsInAndGroup(t *testing.T) {
+	s, ts := newTestServer(t)
+
+	for round := 0; round < 5; round++ {
+		var draft struct{ ID string }
+		if code := call(t, ts, "u1", "/api/forms/and_form/instances", map[string]any{"data": map[string]any{"title": "t"}}, &draft); code != 200 {
+			t.Fatalf("create draft: %d", code)
+		}
+		if code := call(t, ts, "u1", "/api/instances/"+draft.ID+"/submit", nil, nil); code != 200 {
+			t.Fatalf("submit: %d", code)
+		}
+
+		tasks := map[string]string{}
+		rows, err := s.DB.Query(`SELECT assignee_id, id FROM tasks WHERE instance_id=? AND status='PENDING'`, draft.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rows.Next() {
+			var user, id string
+			if err := rows.Scan(&user, &id); err != nil {
+				t.Fatal(err)
+			}
+			tasks[user] = id
+		}
+		rows.Close()
+		if len(tasks) != andApprovers {
+			t.Fatalf("got %d pending tasks, want %d", len(tasks), andApprovers)
+		}
+
+		// everyone approves at once
+		codes := make(chan string, len(tasks))
+		var wg sync.WaitGroup
+		start := make(chan struct{})
+		for user, id := range tasks {
+			wg.Add(1)
+			go func(user, id string) {
+				defer wg.Done()
+				<-start
+				var res map[string]any
+				if code := call(t, ts, user, "/api/tasks/"+id+"/act", map[string]any{"action": "approve"}, &res); code != 200 {
+					codes <- fmt.Sprintf("%s: %d %v", user, code, res["error"])
+				}
+			}(user, id)
+		}
+		close(start)
+		wg.Wait()
+		close(codes)
+		for msg := range codes {
+			t.Errorf("round %d: approval failed: %s", round, msg)
+		}
+
+		var status, node string
+		var approved int
+		if err := s.DB.QueryRow(`SELECT status, current_node FROM instances WHERE id=?`, draft.ID).Scan(&status, &node); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.DB.QueryRow(`SELECT approved_count FROM task_groups WHERE instance_id=? AND node_id='review'`, draft.ID).Scan(&approved); err != nil {
+			t.Fatal(err)
+		}
+		if status != "APPROVED" || node != "end" || approved != andApprovers {
+			t.Fatalf("round %d: instance %s at %s with %d approvals, want APPROVED at end with %d", round, status, node, approved, andApprovers)
+		}
+	}
+}
+
+// TestStaleDataPatchConflicts checks that a data patch written against an
+// older version of the instance is refused instead of overwriting the edit
+// that came in between.
+func TestStaleDataPatchConflicts(t *testing.T) {
+	s, ts := newTestServer(t)
+
+	var draft struct{ ID string }
+	if code := call(t, ts, "u1", "/api/forms/and_form/instances", map[string]any{"data": map[string]any{"title": "t"}}, &draft); code != 200 {
+		t.Fatalf("create draft: %d", code)
+	}
+	if code := call(t, ts, "u1", "/api/instances/"+draft.ID+"/submit", nil, nil); code != 200 {
+		t.Fatalf("submit: %d", code)
+	}
+	taskOf := func(user string) string {
+		var id string
+		if err := s.DB.QueryRow(`SELECT id FROM tasks WHERE instance_id=? AND assignee_id=? AND status='PENDING'`, draft.ID, user).Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var seen int
+	if err := s.DB.QueryRow(`SELECT row_version FROM instances WHERE id=?`, draft.ID).Scan(&seen); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := call(t, ts, "a0", "/api/tasks/"+taskOf("a0")+"/act", map[string]any{"action": "approve", "dataPatch": map[string]any{"note": "a0"}}, nil); code != 200 {
+		t.Fatalf("first patch: %d", code)
+	}
+
+	// a1 still holds the version from before a0's edit
+	b, _ := json.Marshal(map[string]any{"action": "approve", "dataPatch": map[string]any{"note": "a1"}})
+	req, _ := http.NewRequest("POST", ts.URL+"/api/tasks/"+taskOf("a1")+"/act", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(impersonateHdr, "a1")
+	req.Header.Set("If-Match", etag(seen))
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 409 {
+		t.Fatalf("stale patch: got %d, want 409", res.StatusCode)
+	}
+
+	var dataJSON string
+	if err := s.DB.QueryRow(`SELECT data_json FROM instances WHERE id=?`, draft.ID).Scan(&dataJSON); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]any
+	if err := json.Unmarshal([]byte(dataJSON), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data["note"] != "a0" {
+		t.Fatalf("note = %v, want a0", data["note"])
+	}
+
+	// after reloading it goes through
+	if code := call(t, ts, "a1", "/api/tasks/"+taskOf("a1")+"/act", map[string]any{"action": "approve", "dataPatch": map[string]any{"note": "a1"}}, nil); code != 200 {
+		t.Fatalf("patch after reload: %d", code)
+	}
+}
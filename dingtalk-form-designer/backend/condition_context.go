@@ -0,0 +1,81 @@
+package main
+
+import "time"
+
+// conditionContext builds everything an edge condition may reference besides
+// the form data: the applicant (with org membership), instance metadata and
+// the actions already taken at nodeID. Inside a transaction q must be the
+// transaction, so earlier writes of the same action count.
+func conditionContext(q querier, inst *Instance, nodeID string) (JLContext, error) {
+	ctx := JLContext{Form: inst.Data, Now: time.Now().UnixMilli()}
+
+	var name string
+	_ = q.QueryRow(`SELECT name FROM users WHERE id=?`, inst.ApplicantUserID).Scan(&name)
+	depts, err := queryStrings(q, `SELECT dept_id FROM user_depts WHERE user_id=? ORDER BY dept_id`, inst.ApplicantUserID)
+	if err != nil {
+		return ctx, err
+	}
+	roles, err := queryStrings(q, `SELECT role_id FROM user_roles WHERE user_id=? ORDER BY role_id`, inst.ApplicantUserID)
+	if err != nil {
+		return ctx, err
+	}
+	ctx.Applicant = map[string]any{
+		"id":    inst.ApplicantUserID,
+		"name":  name,
+		"depts": anyStrings(depts),
+		"roles": anyStrings(roles),
+	}
+
+	var returns int
+	if err := q.QueryRow(`SELECT COUNT(1) FROM tasks WHERE instance_id=? AND action_taken='return'`, inst.ID).Scan(&returns); err != nil {
+		return ctx, err
+	}
+	ctx.Instance = map[string]any{
+		"id":          inst.ID,
+		"formVersion": float64(inst.FormVersion),
+		"createdAt":   float64(inst.CreatedAt),
+		"submitCount": float64(inst.SubmitCount),
+		"returnCount": float64(returns),
+	}
+
+	actions, err := queryStrings(q, `
+		SELECT action_taken FROM tasks
+		WHERE instance_id=? AND node_id=? AND status='DONE' AND action_taken IS NOT NULL AND action_taken NOT IN ('auto_closed','transferred','withdrawn') AND kind IS NULL
+		ORDER BY completed_at`, inst.ID, nodeID)
+	if err != nil {
+		return ctx, err
+	}
+	ctx.Node = map[string]any{
+		"id":              nodeID,
+		"previousActions": anyStrings(actions),
+	}
+	return ctx, nil
+}
+
+// queryStrings runs a single-column query; the result is never nil so it
+// serializes as an empty array.
+func queryStrings(q querier, query string, args ...any) ([]string, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+// anyStrings converts ss to the []any JsonLogic operates on.
+func anyStrings(ss []string) []any {
+	out := make([]any, len(ss))
+	for i, v := range ss {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// interval is a numeric range; lo/hi may be infinite.
+type interval struct {
+	lo, hi         float64
+	loIncl, hiIncl bool
+}
+
+func fullInterval() interval {
+	return interval{lo: math.Inf(-1), hi: math.Inf(1)}
+}
+
+func (a interval) intersect(b interval) interval {
+	out := a
+	if b.lo > out.lo || (b.lo == out.lo && !b.loIncl) {
+		out.lo, out.loIncl = b.lo, b.loIncl
+	}
+	if b.hi < out.hi || (b.hi == out.hi && !b.hiIncl) {
+		out.hi, out.hiIncl = b.hi, b.hiIncl
+	}
+	return out
+}
+
+func (a interval) empty() bool {
+	return a.lo > a.hi || (a.lo == a.hi && !(a.loIncl && a.hiIncl))
+}
+
+// sample returns a value inside a non-empty interval, for messages.
+func (a interval) sample() float64 {
+	switch {
+	case a.loIncl:
+		return a.lo
+	case a.hiIncl:
+		return a.hi
+	case math.IsInf(a.lo, -1) && math.IsInf(a.hi, 1):
+		return 0
+	case math.IsInf(a.lo, -1):
+		return a.hi - 1
+	case math.IsInf(a.hi, 1):
+		return a.lo + 1
+	}
+	return (a.lo + a.hi) / 2
+}
+
+// numericRanges turns a simple JsonLogic condition into per-variable ranges:
+// comparisons between one var and a number ({"<": [{"var": "form.days"}, 3]},
+// the 3-argument between form) and "and" of those. ok is false for anything
+// else, which the overlap check then ignores.
+func numericRanges(cond any) (map[string]interval, bool) {
+	m, ok := cond.(map[string]any)
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	for op, raw := range m {
+		args, _ := raw.([]any)
+		if op == "and" {
+			out := map[string]interval{}
+			for _, a := range args {
+				sub, ok := numericRanges(a)
+				if !ok {
+					return nil, false
+				}
+				for v, iv := range sub {
+					out[v] = rangeOf(out, v).intersect(iv)
+				}
+			}
+			return out, true
+		}
+		switch op {
+		case "<", "<=", ">", ">=", "==", "===":
+		default:
+			return nil, false
+		}
+		if len(args) == 3 && (op == "<" || op == "<=") {
+			lo, ok1 := args[0].(float64)
+			v, ok2 := varName(args[1])
+			hi, ok3 := args[2].(float64)
+			if !ok1 || !ok2 || !ok3 {
+				return nil, false
+			}
+			incl := op == "<="
+			return map[string]interval{v: {lo: lo, hi: hi, loIncl: incl, hiIncl: incl}}, true
+		}
+		if len(args) != 2 {
+			return nil, false
+		}
+		v, n, flipped, ok := varAndNumber(args[0], args[1])
+		if !ok {
+			return nil, false
+		}
+		if flipped {
+			op = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<=", "==": "==", "===": "==="}[op]
+		}
+		iv := fullInterval()
+		switch op {
+		case "<":
+			iv.hi = n
+		case "<=":
+			iv.hi, iv.hiIncl = n, true
+		case ">":
+			iv.lo = n
+		case ">=":
+			iv.lo, iv.loIncl = n, true
+		case "==", "===":
+			iv = interval{lo: n, hi: n, loIncl: true, hiIncl: true}
+		}
+		return map[string]interval{v: iv}, true
+	}
+	return nil, false
+}
+
+func rangeOf(m map[string]interval, v string) interval {
+	if iv, ok := m[v]; ok {
+		return iv
+	}
+	return fullInterval()
+}
+
+func varName(x any) (string, bool) {
+	m, ok := x.(map[string]any)
+	if !ok || len(m) != 1 {
+		return "", false
+	}
+	s, ok := m["var"].(string)
+	return s, ok
+}
+
+// varAndNumber matches (var, number) in either order; flipped is true for
+// (number, var).
+func varAndNumber(a, b any) (string, float64, bool, bool) {
+	if v, ok := varName(a); ok {
+		n, ok := b.(float64)
+		return v, n, false, ok
+	}
+	if v, ok := varName(b); ok {
+		n, ok := a.(float64)
+		return v, n, true, ok
+	}
+	return "", 0, false, false
+}
+
+// overlapExample returns an assignment satisfying both range sets, or ""
+// when they are disjoint.
+func overlapExample(a, b map[string]interval) string {
+	vars := map[string]bool{}
+	for v := range a {
+		vars[v] = true
+	}
+	for v := range b {
+		vars[v] = true
+	}
+	names := make([]string, 0, len(vars))
+	for v := range vars {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+
+	example := ""
+	for _, v := range names {
+		iv := rangeOf(a, v).intersect(rangeOf(b, v))
+		if iv.empty() {
+			return ""
+		}
+		if example != "" {
+			example += ", "
+		}
+		example += fmt.Sprintf("%s=%v", v, iv.sample())
+	}
+	if example == "" {
+		example = "always"
+	}
+	return example
+}
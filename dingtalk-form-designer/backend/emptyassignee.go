@@ -0,0 +1,119 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// A task node whose assignees resolve to nobody (no assignees on the edge,
+// a role or dept without active members, an inactive user, ...) follows the
+// node's onEmptyAssignee policy: block (default) fails the submit or action
+// with the reason, auto_pass moves on as if the node approved, and admin
+// gives the task to the node's adminUserId. With auto_pass and admin the
+// assignees that did resolve still get their tasks.
+
+// emptyAllowed reports whether edges into nodeID may come without assignees.
+func emptyAllowed(wf *Workflow, nodeID string) bool {
+	n, _ := wf.node(nodeID)
+	return n.OnEmptyAssignee == "auto_pass" || n.OnEmptyAssignee == "admin"
+}
+
+// onEmptyAssignee applies nodeID's policy after resolving its assignees gave
+// resolved plus cause. It returns the assignees to create tasks for, or
+// pass when the node is skipped.
+func onEmptyAssignee(tx *sql.Tx, inst *Instance, schema *FormSchema, nodeID string, resolved []Assignee, cause error, now int64) ([]Assignee, bool, error) {
+	var empty *emptyAssigneeError
+	if !errors.As(cause, &empty) {
+		return nil, false, cause
+	}
+	n, _ := schema.Workflow.node(nodeID)
+	name := n.Name
+	if name == "" {
+		name = nodeID
+	}
+	switch n.OnEmptyAssignee {
+	case "auto_pass", "admin":
+		if len(resolved) > 0 {
+			return resolved, false, nil
+		}
+	default:
+		return nil, false, fmt.Errorf("no approver for node %q: %s", name, empty.msg)
+	}
+
+	payload := map[string]any{"policy": n.OnEmptyAssignee, "reason": empty.msg}
+	if n.OnEmptyAssignee == "admin" {
+		var active bool
+		if err := tx.QueryRow(`SELECT active FROM users WHERE id=?`, n.AdminUserID).Scan(&active); err != nil && err != sql.ErrNoRows {
+			return nil, false, err
+		}
+		if !active {
+			return nil, false, fmt.Errorf("no approver for node %q: %s, and admin %s is unknown or inactive", name, empty.msg, n.AdminUserID)
+		}
+		payload["adminUserId"] = n.AdminUserID
+	}
+	if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "no_assignee", NodeID: nodeID, Payload: payload}, now); err != nil {
+		return nil, false, err
+	}
+	if n.OnEmptyAssignee == "auto_pass" {
+		return nil, true, nil
+	}
+	return []Assignee{{Type: "user", ID: n.AdminUserID}}, false, nil
+}
+
+type OrphanTaskRow struct {
+	InboxTaskRow
+	Reason string `json:"reason"`
+}
+
+// ListOrphanedTasks (admins only) reports pending tasks nobody can act on:
+// their user is unknown or deactivated, or their role or dept has no active
+// members.
+func (s *Server) ListOrphanedTasks(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query(`
+		SELECT
+		  t.id, t.node_id, t.status, t.assignee_type, t.assignee_id, t.created_at,
+		  i.id, i.status, i.current_node, i.applicant_user_id,
+		  COALESCE(u.name,''),
+		  f.id, f.name, i.form_version
+		FROM tasks t
+		JOIN instances i ON i.id = t.instance_id
+		JOIN forms f ON f.id = i.form_id AND f.version = i.form_version
+		LEFT JOIN users u ON u.id = i.applicant_user_id
+		WHERE t.status='PENDING'
+		AND (
+		 (t.assignee_type='user' AND NOT EXISTS (SELECT 1 FROM users x WHERE x.id=t.assignee_id AND x.active=1))
+		 OR
+		 (t.assignee_type='role' AND NOT EXISTS (SELECT 1 FROM user_roles ur JOIN users x ON x.id=ur.user_id WHERE ur.role_id=t.assignee_id AND x.active=1))
+		 OR
+		 (t.assignee_type='dept' AND NOT EXISTS (SELECT 1 FROM user_depts ud JOIN users x ON x.id=ud.user_id WHERE ud.dept_id=t.assignee_id AND x.active=1))
+		)
+		ORDER BY t.created_at
+	`)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []OrphanTaskRow{}
+	for rows.Next() {
+		var x OrphanTaskRow
+		if err := rows.Scan(
+			&x.TaskID, &x.TaskNodeID, &x.TaskStatus, &x.AssigneeType, &x.AssigneeID, &x.CreatedAt,
+			&x.InstanceID, &x.InstanceStatus, &x.CurrentNode, &x.ApplicantID,
+			&x.ApplicantName,
+			&x.FormID, &x.FormName, &x.FormVersion,
+		); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		x.Reason = "no active members"
+		if x.AssigneeType == "user" {
+			x.Reason = "user unknown or inactive"
+		}
+		out = append(out, x)
+	}
+	writeJSON(w, 200, out)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// FieldError is one invalid field value. Row and Column locate the cell
+// when the error is inside a subtable.
+type FieldError struct {
+	FieldID string `json:"fieldId"`
+	Row     *int   `json:"row,omitempty"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// fieldValidator checks a non-empty value against its field definition and
+// returns an error message, or "" when the value is acceptable.
+type fieldValidator func(s *Server, f Field, v any) string
+
+var fieldValidators = map[string]fieldValidator{
+	"text":       validateTextValue,
+	"textarea":   validateTextValue,
+	"number":     validateNumberValue,
+	"money":      validateNumberValue,
+	"select":     validateSelectValue,
+	"switch":     validateSwitchValue,
+	"date":       validateDateValue,
+	"attachment": validateAttachmentValue,
+	"member":     validateMemberValue,
+	"department": validateDepartmentValue,
+}
+
+// validateFieldValues type-checks every field value present in data,
+// including each subtable row, and returns all errors at once.
+func (s *Server) validateFieldValues(schema *FormSchema, data map[string]any) []FieldError {
+	var errs []FieldError
+	for _, f := range schema.Fields {
+		v := data[f.ID]
+		if isEmptyValue(v) {
+			continue
+		}
+		if f.Type == "subtable" {
+			errs = append(errs, s.validateSubtable(f, v)...)
+			continue
+		}
+		if fn, ok := fieldValidators[f.Type]; ok {
+			if msg := fn(s, f, v); msg != "" {
+				errs = append(errs, FieldError{FieldID: f.ID, Message: msg})
+			}
+		}
+	}
+	return errs
+}
+
+func (s *Server) validateSubtable(f Field, v any) []FieldError {
+	rows, ok := v.([]any)
+	if !ok {
+		return []FieldError{{FieldID: f.ID, Message: "must be a list of rows"}}
+	}
+	var errs []FieldError
+	if f.MaxRows > 0 && len(rows) > f.MaxRows {
+		errs = append(errs, FieldError{FieldID: f.ID, Message: fmt.Sprintf("at most %d rows allowed", f.MaxRows)})
+	}
+	for i, r := range rows {
+		idx := i
+		row, ok := r.(map[string]any)
+		if !ok {
+			errs = append(errs, FieldError{FieldID: f.ID, Row: &idx, Message: "row must be an object"})
+			continue
+		}
+		for _, c := range f.Columns {
+			cv := row[c.ID]
+			if isEmptyValue(cv) {
+				if c.Required {
+					errs = append(errs, FieldError{FieldID: f.ID, Row: &idx, Column: c.ID, Message: "required"})
+				}
+				continue
+			}
+			if fn, ok := fieldValidators[c.Type]; ok {
+				if msg := fn(s, c, cv); msg != "" {
+					errs = append(errs, FieldError{FieldID: f.ID, Row: &idx, Column: c.ID, Message: msg})
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func validateTextValue(_ *Server, _ Field, v any) string {
+	if _, ok := v.(string); !ok {
+		return "must be a string"
+	}
+	return ""
+}
+
+func validateNumberValue(_ *Server, f Field, v any) string {
+	n, ok := v.(float64)
+	if !ok {
+		return "must be a number"
+	}
+	if f.Min != nil && n < *f.Min {
+		return fmt.Sprintf("must be >= %v", *f.Min)
+	}
+	if f.Max != nil && n > *f.Max {
+		return fmt.Sprintf("must be <= %v", *f.Max)
+	}
+	if f.Step > 0 {
+		base := 0.0
+		if f.Min != nil {
+			base = *f.Min
+		}
+		if q := (n - base) / f.Step; math.Abs(q-math.Round(q)) > 1e-9 {
+			return fmt.Sprintf("must be a multiple of %v", f.Step)
+		}
+	}
+	return ""
+}
+
+func validateSelectValue(_ *Server, f Field, v any) string {
+	sv, ok := v.(string)
+	if !ok {
+		return "must be a string"
+	}
+	for _, o := range f.Options {
+		if o == sv {
+			return ""
+		}
+	}
+	return "not one of the options: " + sv
+}
+
+func validateSwitchValue(_ *Server, _ Field, v any) string {
+	if _, ok := v.(bool); !ok {
+		return "must be true or false"
+	}
+	return ""
+}
+
+func validateDateValue(_ *Server, _ Field, v any) string {
+	sv, ok := v.(string)
+	if !ok {
+		return "must be a date string"
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", time.RFC3339} {
+		if _, err := time.Parse(layout, sv); err == nil {
+			return ""
+		}
+	}
+	return "invalid date: " + sv
+}
+
+func validateAttachmentValue(_ *Server, _ Field, v any) string {
+	if _, ok := stringList(v); !ok {
+		return "must be a string or a list of strings"
+	}
+	return ""
+}
+
+func validateMemberValue(s *Server, _ Field, v any) string {
+	return s.validateIDs(v, `SELECT COUNT(1) FROM users WHERE id=?`, "unknown user: ")
+}
+
+func validateDepartmentValue(s *Server, _ Field, v any) string {
+	return s.validateIDs(v, `SELECT COUNT(1) FROM depts WHERE id=?`, "unknown department: ")
+}
+
+func (s *Server) validateIDs(v any, q, unknown string) string {
+	ids, ok := stringList(v)
+	if !ok {
+		return "must be an id or a list of ids"
+	}
+	for _, id := range ids {
+		var cnt int
+		if err := s.DB.QueryRow(q, id).Scan(&cnt); err != nil {
+			return err.Error()
+		}
+		if cnt == 0 {
+			return unknown + id
+		}
+	}
+	return ""
+}
+
+// stringList accepts a single string or a list of strings.
+func stringList(v any) ([]string, bool) {
+	switch t := v.(type) {
+	case string:
+		return []string{t}, true
+	case []any:
+		out := make([]string, 0, len(t))
+		for _, it := range t {
+			s, ok := it.(string)
+			if !ok {
+				return nil, false
+			}
+			out = append(out, s)
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+func isEmptyValue(v any) bool {
+	if v == nil || isEmptyString(v) {
+		return true
+	}
+	arr, ok := v.([]any)
+	return ok && len(arr) == 0
+}
+
+func writeFieldErrors(w http.ResponseWriter, errs []FieldError) {
+	writeJSON(w, 400, map[string]any{"error": "field validation failed", "fieldErrors": errs})
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -21,11 +22,11 @@ type InstanceListRow struct {
 }
 
 func (s *Server) ListInstances(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
+	userID := currentUserID(r)
 	scope := r.URL.Query().Get("scope")   // applicant (MVP only)
 	status := r.URL.Query().Get("status") // DRAFT|RUNNING|DONE
-	if userID == "" || scope != "applicant" || (status != "DRAFT" && status != "RUNNING" && status != "DONE") {
-		writeJSON(w, 400, map[string]any{"error": "query required: userId, scope=applicant, status=DRAFT|RUNNING|DONE"})
+	if scope != "applicant" || (status != "DRAFT" && status != "RUNNING" && status != "DONE") {
+		writeJSON(w, 400, map[string]any{"error": "query required: scope=applicant, status=DRAFT|RUNNING|DONE"})
 		return
 	}
 
@@ -36,7 +37,7 @@ func (s *Server) ListInstances(w http.ResponseWriter, r *http.Request) {
 	case "RUNNING":
 		whereStatus = `i.status='RUNNING'`
 	case "DONE":
-		whereStatus = `(i.status='APPROVED' OR i.status='REJECTED')`
+		whereStatus = `i.status IN ('APPROVED','REJECTED','WITHDRAWN')`
 	}
 
 	q := `
@@ -67,21 +68,17 @@ func (s *Server) ListInstances(w http.ResponseWriter, r *http.Request) {
 }
 
 type UpdateInstanceDataReq struct {
-	UserID    string         `json:"userId"`
 	DataPatch map[string]any `json:"dataPatch"`
 }
 
 func (s *Server) UpdateInstanceData(w http.ResponseWriter, r *http.Request) {
 	instID := chi.URLParam(r, "id")
+	userID := currentUserID(r)
 	var req UpdateInstanceDataReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, 400, map[string]any{"error": "bad json"})
 		return
 	}
-	if req.UserID == "" {
-		writeJSON(w, 400, map[string]any{"error": "userId required"})
-		return
-	}
 	if req.DataPatch == nil {
 		req.DataPatch = map[string]any{}
 	}
@@ -91,11 +88,15 @@ func (s *Server) UpdateInstanceData(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, 404, map[string]any{"error": err.Error()})
 		return
 	}
-	if inst.ApplicantUserID != req.UserID {
+	if inst.ApplicantUserID != userID {
 		writeJSON(w, 403, map[string]any{"error": "only applicant can edit instance data"})
 		return
 	}
-	if inst.CurrentNode != "start" {
+	if !ifMatch(r, inst.RowVersion) {
+		writeJSON(w, 409, map[string]any{"error": errConflict.Error(), "rowVersion": inst.RowVersion})
+		return
+	}
+	if !slices.Contains(inst.ActiveNodes(), "start") {
 		writeJSON(w, 400, map[string]any{"error": "instance not editable at current node (only start)"})
 		return
 	}
@@ -110,16 +111,58 @@ func (s *Server) UpdateInstanceData(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := rejectCalculatedWrites(schema, req.DataPatch); err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+
 	for k, v := range req.DataPatch {
 		inst.Data[k] = v
 	}
+	if err := applyCalculations(schema, inst.Data); err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	visible, err := s.visibilityAt(schema, inst, "start")
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := enforceVisible(visible, "start", req.DataPatch); err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	if errs := s.validateFieldValues(schema, inst.Data); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
 	dataJSON, _ := json.Marshal(inst.Data)
 	now := time.Now().UnixMilli()
-	_, err = s.DB.Exec(`UPDATE instances SET data_json=?, updated_at=? WHERE id=?`, string(dataJSON), now, inst.ID)
+	tx, err := s.DB.Begin()
 	if err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
+	defer tx.Rollback()
+	if err := execVersioned(tx, `UPDATE instances SET data_json=?, updated_at=?, row_version=row_version+1 WHERE id=? AND row_version=?`,
+		string(dataJSON), now, inst.ID, inst.RowVersion); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if err := saveRevision(tx, inst.ID, userID, "start", inst.Data, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "data_updated", NodeID: "start", ActorUserID: userID,
+		Payload: map[string]any{"fields": sortedKeys(req.DataPatch)}}, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
 
-	writeJSON(w, 200, map[string]any{"ok": true, "instanceId": inst.ID, "updatedAt": now})
+	w.Header().Set("ETag", etag(inst.RowVersion+1))
+	writeJSON(w, 200, map[string]any{"ok": true, "instanceId": inst.ID, "updatedAt": now, "rowVersion": inst.RowVersion + 1})
 }
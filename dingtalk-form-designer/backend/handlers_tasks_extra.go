@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"net/http"
+	"slices"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -24,22 +25,18 @@ type DoneTaskRow struct {
 }
 
 func (s *Server) ListDoneTasks(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		writeJSON(w, 400, map[string]any{"error": "userId required"})
-		return
-	}
+	userID := currentUserID(r)
 
 	rows, err := s.DB.Query(`
 		SELECT
 		  t.id, t.node_id, COALESCE(t.action_taken,''), COALESCE(t.completed_at,0),
 		  i.id, i.status, i.current_node,
 		  f.id, f.name,
-		  u.name
+		  COALESCE(u.name,'')
 		FROM tasks t
 		JOIN instances i ON i.id=t.instance_id
 		JOIN forms f ON f.id=i.form_id AND f.version=i.form_version
-		JOIN users u ON u.id=i.applicant_user_id
+		LEFT JOIN users u ON u.id=i.applicant_user_id
 		WHERE t.status='DONE' AND t.actor_user_id=?
 		ORDER BY t.completed_at DESC
 	`, userID)
@@ -74,21 +71,52 @@ type TaskDetailResp struct {
 
 func (s *Server) GetTaskDetail(w http.ResponseWriter, r *http.Request) {
 	taskID := chi.URLParam(r, "id")
+	viewerID := currentUserID(r)
 	task, inst, schema, err := s.loadTaskInstanceSchema(taskID)
 	if err != nil {
 		writeJSON(w, 404, map[string]any{"error": err.Error()})
 		return
 	}
 
+	// non-applicants see the fields visible at the task's node when it is
+	// one of theirs, else what the policies of their own nodes show
+	data := inst.Data
+	viewSchema := *schema
+	if viewerID != inst.ApplicantUserID {
+		nodes, err := s.viewerNodes(inst, viewerID)
+		if err != nil {
+			writeViewError(w, err)
+			return
+		}
+		if slices.Contains(nodes, task.NodeID) {
+			nodes = []string{task.NodeID}
+		}
+		visible, err := s.visibilityUnion(schema, inst, nodes)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		data = stripHidden(inst.Data, visible)
+		viewSchema = schemaForViewer(schema, visible)
+	}
+	targets, err := returnTargets(s.DB, &schema.Workflow, task)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+
 	resp := TaskDetailResp{
 		Task: map[string]any{
-			"id":           task.ID,
-			"groupId":      task.GroupID,
-			"instanceId":   task.InstanceID,
-			"nodeId":       task.NodeID,
-			"status":       task.Status,
-			"assigneeType": task.AssigneeType,
-			"assigneeId":   task.AssigneeID,
+			"id":            task.ID,
+			"groupId":       task.GroupID,
+			"instanceId":    task.InstanceID,
+			"nodeId":        task.NodeID,
+			"status":        task.Status,
+			"assigneeType":  task.AssigneeType,
+			"assigneeId":    task.AssigneeID,
+			"kind":          task.Kind,
+			"parentTaskId":  task.ParentTaskID,
+			"returnTargets": targets,
 		},
 		Instance: map[string]any{
 			"id":              inst.ID,
@@ -96,10 +124,10 @@ func (s *Server) GetTaskDetail(w http.ResponseWriter, r *http.Request) {
 			"formVersion":     inst.FormVersion,
 			"status":          inst.Status,
 			"currentNode":     inst.CurrentNode,
-			"data":            inst.Data,
+			"data":            data,
 			"applicantUserId": inst.ApplicantUserID,
 		},
-		Schema: *schema,
+		Schema: viewSchema,
 	}
 
 	b, _ := json.Marshal(resp)
@@ -3,99 +3,411 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 )
 
+// JsonLogic (https://jsonlogic.com) evaluator used for edge conditions and
+// visibleWhen rules. Semantics follow json-logic-js: loose equality and
+// truthiness are JavaScript's, except that an empty array is falsy.
+
 type JLContext struct {
-	Form map[string]any
+	Form      map[string]any
+	Applicant map[string]any // id, name, depts, roles
+	Instance  map[string]any // id, formVersion, createdAt, submitCount, returnCount
+	Node      map[string]any // id, previousActions
+	Now       int64          // unix millis
+}
+
+// data is the object `var` paths resolve against, e.g. {"var": "form.days"}
+// or {"in": ["d2", {"var": "applicant.depts"}]}.
+func (c JLContext) data() map[string]any {
+	d := map[string]any{"form": c.Form}
+	if c.Applicant != nil {
+		d["applicant"] = c.Applicant
+	}
+	if c.Instance != nil {
+		d["instance"] = c.Instance
+	}
+	if c.Node != nil {
+		d["node"] = c.Node
+	}
+	if c.Now != 0 {
+		d["now"] = float64(c.Now)
+	}
+	return d
 }
 
+// EvalJsonLogic evaluates a condition; a nil condition is always true.
 func EvalJsonLogic(expr any, ctx JLContext) (bool, error) {
 	if expr == nil {
 		return true, nil
 	}
-	m, ok := expr.(map[string]any)
+	v, err := applyJsonLogic(expr, ctx.data())
+	if err != nil {
+		return false, err
+	}
+	return jlTruthy(v), nil
+}
+
+func applyJsonLogic(rule any, data any) (any, error) {
+	switch t := rule.(type) {
+	case []any:
+		out := make([]any, len(t))
+		for i, it := range t {
+			v, err := applyJsonLogic(it, data)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = v
+		}
+		return out, nil
+	case map[string]any:
+		if len(t) != 1 {
+			return rule, nil // not a rule: an object literal, as in json-logic-js
+		}
+		for op, raw := range t {
+			return jlOperation(op, raw, data)
+		}
+	}
+	return rule, nil
+}
+
+func jlOperation(op string, raw any, data any) (any, error) {
+	args, ok := raw.([]any)
 	if !ok {
-		return false, errors.New("condition must be object")
-	}
-	for op, raw := range m {
-		args, _ := raw.([]any)
-		switch op {
-		case "==":
-			a, _ := evalValue(args[0], ctx)
-			b, _ := evalValue(args[1], ctx)
-			return equal(a, b), nil
-		case "!=":
-			a, _ := evalValue(args[0], ctx)
-			b, _ := evalValue(args[1], ctx)
-			return !equal(a, b), nil
-		case ">", "<", ">=", "<=":
-			a, _ := evalValue(args[0], ctx)
-			b, _ := evalValue(args[1], ctx)
-			af := toFloat(a)
-			bf := toFloat(b)
-			switch op {
-			case ">":
-				return af > bf, nil
-			case "<":
-				return af < bf, nil
-			case ">=":
-				return af >= bf, nil
-			case "<=":
-				return af <= bf, nil
-			}
-		case "and":
-			for _, it := range args {
-				ok, err := EvalJsonLogic(it, ctx)
-				if err != nil {
-					return false, err
-				}
-				if !ok {
-					return false, nil
-				}
+		args = []any{raw}
+	}
+
+	// operators that control evaluation of their own arguments
+	switch op {
+	case "if", "?:":
+		i := 0
+		for ; i+1 < len(args); i += 2 {
+			c, err := applyJsonLogic(args[i], data)
+			if err != nil {
+				return nil, err
 			}
-			return true, nil
-		case "or":
-			for _, it := range args {
-				ok, err := EvalJsonLogic(it, ctx)
-				if err != nil {
-					return false, err
-				}
-				if ok {
+			if jlTruthy(c) {
+				return applyJsonLogic(args[i+1], data)
+			}
+		}
+		if i < len(args) {
+			return applyJsonLogic(args[i], data)
+		}
+		return nil, nil
+	case "and", "or":
+		var last any
+		for _, it := range args {
+			v, err := applyJsonLogic(it, data)
+			if err != nil {
+				return nil, err
+			}
+			last = v
+			if (op == "and") != jlTruthy(v) {
+				return v, nil
+			}
+		}
+		return last, nil
+	case "map", "filter", "all", "none", "some", "reduce":
+		return jlArrayOp(op, args, data)
+	}
+
+	vals := make([]any, len(args))
+	for i, a := range args {
+		v, err := applyJsonLogic(a, data)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	arg := func(i int) any {
+		if i < len(vals) {
+			return vals[i]
+		}
+		return nil
+	}
+
+	switch op {
+	case "var":
+		return jlVar(data, arg(0), arg(1)), nil
+	case "missing":
+		keys := vals
+		if arr, ok := arg(0).([]any); ok {
+			keys = arr
+		}
+		missing := []any{}
+		for _, k := range keys {
+			v := jlVar(data, k, nil)
+			if v == nil || isEmptyString(v) {
+				missing = append(missing, k)
+			}
+		}
+		return missing, nil
+	case "missing_some":
+		need := int(jlNumber(arg(0)))
+		keys, _ := arg(1).([]any)
+		missing := []any{}
+		for _, k := range keys {
+			v := jlVar(data, k, nil)
+			if v == nil || isEmptyString(v) {
+				missing = append(missing, k)
+			}
+		}
+		if len(keys)-len(missing) >= need {
+			return []any{}, nil
+		}
+		return missing, nil
+	case "==":
+		return looseEqual(arg(0), arg(1)), nil
+	case "!=":
+		return !looseEqual(arg(0), arg(1)), nil
+	case "===":
+		return strictEqual(arg(0), arg(1)), nil
+	case "!==":
+		return !strictEqual(arg(0), arg(1)), nil
+	case "!":
+		return !jlTruthy(arg(0)), nil
+	case "!!":
+		return jlTruthy(arg(0)), nil
+	case ">":
+		return jlLess(arg(1), arg(0), false), nil
+	case ">=":
+		return jlLess(arg(1), arg(0), true), nil
+	case "<", "<=":
+		orEq := op == "<="
+		if len(vals) == 3 {
+			return jlLess(arg(0), arg(1), orEq) && jlLess(arg(1), arg(2), orEq), nil
+		}
+		return jlLess(arg(0), arg(1), orEq), nil
+	case "max", "min":
+		if len(vals) == 0 {
+			return nil, nil
+		}
+		best := jlNumber(vals[0])
+		for _, v := range vals[1:] {
+			n := jlNumber(v)
+			if math.IsNaN(n) || (op == "max" && n > best) || (op == "min" && n < best) {
+				best = n
+			}
+		}
+		return best, nil
+	case "+":
+		sum := 0.0
+		for _, v := range vals {
+			sum += jlNumber(v)
+		}
+		return sum, nil
+	case "*":
+		prod := 1.0
+		for _, v := range vals {
+			prod *= jlNumber(v)
+		}
+		return prod, nil
+	case "-":
+		if len(vals) == 1 {
+			return -jlNumber(vals[0]), nil
+		}
+		return jlNumber(arg(0)) - jlNumber(arg(1)), nil
+	case "/":
+		return jlNumber(arg(0)) / jlNumber(arg(1)), nil
+	case "%":
+		return math.Mod(jlNumber(arg(0)), jlNumber(arg(1))), nil
+	case "in":
+		switch hay := arg(1).(type) {
+		case string:
+			return strings.Contains(hay, jlString(arg(0))), nil
+		case []any:
+			for _, it := range hay {
+				if strictEqual(it, arg(0)) {
 					return true, nil
 				}
 			}
-			return false, nil
-		default:
-			return false, errors.New("unsupported op: " + op)
 		}
+		return false, nil
+	case "cat":
+		var sb strings.Builder
+		for _, v := range vals {
+			sb.WriteString(jlString(v))
+		}
+		return sb.String(), nil
+	case "substr":
+		s := []rune(jlString(arg(0)))
+		start := int(jlNumber(arg(1)))
+		if start < 0 {
+			start = max(len(s)+start, 0)
+		}
+		start = min(start, len(s))
+		end := len(s)
+		if len(vals) > 2 {
+			n := int(jlNumber(arg(2)))
+			if n < 0 {
+				end = max(len(s)+n, start)
+			} else {
+				end = min(start+n, len(s))
+			}
+		}
+		return string(s[start:end]), nil
+	case "merge":
+		out := []any{}
+		for _, v := range vals {
+			if arr, ok := v.([]any); ok {
+				out = append(out, arr...)
+			} else {
+				out = append(out, v)
+			}
+		}
+		return out, nil
+	case "log":
+		return arg(0), nil
 	}
-	return true, nil
+	return nil, errors.New("unsupported op: " + op)
 }
 
-func evalValue(v any, ctx JLContext) (any, error) {
-	if m, ok := v.(map[string]any); ok {
-		if vv, ok2 := m["var"]; ok2 {
-			path, _ := vv.(string)
-			if len(path) >= 5 && path[:5] == "form." {
-				key := path[5:]
-				return ctx.Form[key], nil
+// jlArrayOp implements the operators whose second argument is logic applied
+// to each element of the first, with the element as the data object.
+func jlArrayOp(op string, args []any, data any) (any, error) {
+	var items []any
+	if len(args) > 0 {
+		v, err := applyJsonLogic(args[0], data)
+		if err != nil {
+			return nil, err
+		}
+		items, _ = v.([]any)
+	}
+	var logic any
+	if len(args) > 1 {
+		logic = args[1]
+	}
+
+	if op == "reduce" {
+		var acc any
+		if len(args) > 2 {
+			v, err := applyJsonLogic(args[2], data)
+			if err != nil {
+				return nil, err
 			}
-			if path == "form" {
-				return ctx.Form, nil
+			acc = v
+		}
+		for _, it := range items {
+			v, err := applyJsonLogic(logic, map[string]any{"current": it, "accumulator": acc})
+			if err != nil {
+				return nil, err
+			}
+			acc = v
+		}
+		return acc, nil
+	}
+
+	switch op {
+	case "all":
+		if len(items) == 0 {
+			return false, nil
+		}
+	case "map", "filter":
+		out := []any{}
+		for _, it := range items {
+			v, err := applyJsonLogic(logic, it)
+			if err != nil {
+				return nil, err
+			}
+			if op == "map" {
+				out = append(out, v)
+			} else if jlTruthy(v) {
+				out = append(out, it)
 			}
-			return nil, nil
 		}
-		b, err := EvalJsonLogic(m, ctx)
+		return out, nil
+	}
+	for _, it := range items {
+		v, err := applyJsonLogic(logic, it)
 		if err != nil {
 			return nil, err
 		}
-		return b, nil
+		t := jlTruthy(v)
+		switch {
+		case op == "all" && !t:
+			return false, nil
+		case op == "some" && t:
+			return true, nil
+		case op == "none" && t:
+			return false, nil
+		}
 	}
-	return v, nil
+	return op != "some", nil
 }
 
-func toFloat(v any) float64 {
+// jlVar resolves a dotted path ("form.items.0.cost") against data.
+func jlVar(data any, path any, def any) any {
+	var key string
+	switch p := path.(type) {
+	case nil:
+		return data
+	case string:
+		key = p
+	case float64:
+		key = strconv.FormatFloat(p, 'f', -1, 64)
+	default:
+		key = jlString(p)
+	}
+	if key == "" {
+		return data
+	}
+	cur := data
+	for _, seg := range strings.Split(key, ".") {
+		switch c := cur.(type) {
+		case map[string]any:
+			v, ok := c[seg]
+			if !ok {
+				return def
+			}
+			cur = v
+		case []any:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(c) {
+				return def
+			}
+			cur = c[i]
+		default:
+			return def
+		}
+	}
+	return cur
+}
+
+/* ---------------- JavaScript-like value semantics ---------------- */
+
+func jlTruthy(v any) bool {
 	switch t := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return t
+	case float64:
+		return t != 0 && !math.IsNaN(t)
+	case string:
+		return t != ""
+	case []any:
+		return len(t) > 0
+	case json.Number:
+		f, _ := t.Float64()
+		return f != 0
+	}
+	return true
+}
+
+// jlNumber is JavaScript's Number(v).
+func jlNumber(v any) float64 {
+	switch t := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if t {
+			return 1
+		}
+		return 0
 	case float64:
 		return t
 	case int:
@@ -103,25 +415,134 @@ func toFloat(v any) float64 {
 	case int64:
 		return float64(t)
 	case json.Number:
-		f, _ := t.Float64()
+		f, err := t.Float64()
+		if err != nil {
+			return math.NaN()
+		}
 		return f
 	case string:
-		var n json.Number = json.Number(t)
-		f, _ := n.Float64()
+		s := strings.TrimSpace(t)
+		if s == "" {
+			return 0
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return math.NaN()
+		}
 		return f
-	default:
-		return 0
+	case []any:
+		if len(t) == 0 {
+			return 0
+		}
+		if len(t) == 1 {
+			return jlNumber(t[0])
+		}
 	}
+	return math.NaN()
 }
 
-func equal(a, b any) bool {
-	// numeric-ish compare first when possible
-	af := toFloat(a)
-	bf := toFloat(b)
-	if (af != 0) || (bf != 0) {
-		return af == bf
+// jlString is JavaScript's String(v).
+func jlString(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case float64:
+		if math.IsNaN(t) {
+			return "NaN"
+		}
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case []any:
+		parts := make([]string, len(t))
+		for i, it := range t {
+			if it != nil {
+				parts[i] = jlString(it)
+			}
+		}
+		return strings.Join(parts, ",")
+	case map[string]any:
+		return "[object Object]"
+	}
+	return fmt.Sprint(v)
+}
+
+func jlIsPrimitive(v any) bool {
+	switch v.(type) {
+	case []any, map[string]any:
+		return false
+	}
+	return true
+}
+
+// looseEqual is JavaScript's ==.
+func looseEqual(a, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if !jlIsPrimitive(a) && !jlIsPrimitive(b) {
+		return false // distinct objects are never == in JS
+	}
+	if !jlIsPrimitive(a) {
+		a = jlString(a)
+	}
+	if !jlIsPrimitive(b) {
+		b = jlString(b)
+	}
+	as, aStr := a.(string)
+	bs, bStr := b.(string)
+	if aStr && bStr {
+		return as == bs
+	}
+	if ab, ok := a.(bool); ok {
+		if bb, ok := b.(bool); ok {
+			return ab == bb
+		}
+	}
+	return jlNumber(a) == jlNumber(b)
+}
+
+// strictEqual is JavaScript's ===.
+func strictEqual(a, b any) bool {
+	switch x := a.(type) {
+	case nil:
+		return b == nil
+	case string:
+		y, ok := b.(string)
+		return ok && x == y
+	case bool:
+		y, ok := b.(bool)
+		return ok && x == y
+	case float64, int, int64, json.Number:
+		switch b.(type) {
+		case float64, int, int64, json.Number:
+			return jlNumber(a) == jlNumber(b)
+		}
+	}
+	return false
+}
+
+// jlLess is JavaScript's a < b (or a <= b).
+func jlLess(a, b any, orEqual bool) bool {
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			return as < bs || (orEqual && as == bs)
+		}
+	}
+	x, y := jlNumber(a), jlNumber(b)
+	return x < y || (orEqual && x == y)
+}
+
+/* ---------------- helpers shared with calc ---------------- */
+
+func toFloat(v any) float64 {
+	f := jlNumber(v)
+	if math.IsNaN(f) {
+		return 0
 	}
-	return stringify(a) == stringify(b)
+	return f
 }
 
 func stringify(v any) string {
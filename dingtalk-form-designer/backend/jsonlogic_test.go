@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestJsonLogicConformance runs vectors of the official JsonLogic suite
+// (https://jsonlogic.com/tests.json) in its format, so the file can be
+// swapped for a fresh download: strings are section headers, every other
+// entry is [rule, data, expected].
+func TestJsonLogicConformance(t *testing.T) {
+	b, err := os.ReadFile("testdata/jsonlogic_tests.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []any
+	if err := json.Unmarshal(b, &entries); err != nil {
+		t.Fatal(err)
+	}
+
+	section, n := "", 0
+	for _, e := range entries {
+		if s, ok := e.(string); ok {
+			section = s
+			continue
+		}
+		c, ok := e.([]any)
+		if !ok || len(c) != 3 {
+			t.Fatalf("%s: malformed entry %v", section, e)
+		}
+		n++
+		rule, data, want := c[0], c[1], c[2]
+		got, err := applyJsonLogic(rule, data)
+		if err != nil {
+			t.Errorf("%s: %s with %s: %v", section, jsonText(rule), jsonText(data), err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: %s with %s = %s, want %s", section, jsonText(rule), jsonText(data), jsonText(got), jsonText(want))
+		}
+	}
+	if n == 0 {
+		t.Fatal("no test vectors")
+	}
+}
+
+func jsonText(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
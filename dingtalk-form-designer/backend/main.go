@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -14,7 +15,7 @@ import (
 func main() {
 	dbPath := getenv("DB_PATH", "./data.db")
 
-	db, err := sql.Open("sqlite", dbPath)
+	db, err := sql.Open("sqlite", sqliteDSN(dbPath))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -23,6 +24,12 @@ func main() {
 	if err := migrate(db); err != nil {
 		log.Fatal(err)
 	}
+	if len(os.Args) > 1 && os.Args[1] == "import-org" {
+		if err := runImportOrg(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if err := seedDemo(db); err != nil {
 		log.Fatal(err)
 	}
@@ -31,7 +38,7 @@ func main() {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "http://127.0.0.1:3000"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "If-Match", impersonateHdr},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -40,27 +47,84 @@ func main() {
 		writeJSON(w, http.StatusOK, map[string]any{"ok": true})
 	})
 
-	s := &Server{DB: db}
+	s := &Server{
+		DB:         db,
+		AuthSecret: []byte(os.Getenv("AUTH_SECRET")),
+		DevMode:    os.Getenv("AUTH_DEV_MODE") == "1",
+	}
+	if len(s.AuthSecret) == 0 {
+		s.AuthSecret = []byte(newID("secret"))
+		log.Println("⚠️  AUTH_SECRET not set, using a random key (sessions end on restart)")
+	}
+	if s.DevMode {
+		log.Println("⚠️  AUTH_DEV_MODE=1: " + impersonateHdr + " header is trusted")
+	}
 
 	r.Route("/api", func(api chi.Router) {
-		// forms
+		api.Use(s.Authenticate)
+
+		// auth
+		api.Post("/auth/login", s.Login)
+		api.Post("/auth/logout", s.Logout)
+
+		// forms (read-only endpoints are public)
 		api.Get("/forms", s.ListForms)
 		api.Get("/forms/{id}", s.GetForm)
-		api.Post("/forms", s.SaveForm)
-		api.Post("/forms/{id}/publish", s.PublishForm)
-
-		// instances
-		api.Post("/forms/{id}/instances", s.CreateInstanceDraft)
-		api.Get("/instances/{id}", s.GetInstance)
-		api.Put("/instances/{id}/data", s.UpdateInstanceData)
-		api.Post("/instances/{id}/submit", s.SubmitInstance)
-		api.Get("/instances", s.ListInstances)
-
-		// tasks
-		api.Get("/tasks/inbox", s.ListInboxTasks)
-		api.Get("/tasks/done", s.ListDoneTasks)
-		api.Get("/tasks/{id}", s.GetTaskDetail)
-		api.Post("/tasks/{id}/act", s.ActOnTask)
+		api.Post("/forms/validate", s.ValidateForm)
+
+		api.Group(func(api chi.Router) {
+			api.Use(RequireUser)
+
+			api.Get("/auth/me", s.Me)
+
+			// forms
+			api.Post("/forms", s.SaveForm)
+			api.Post("/forms/{id}/publish", s.PublishForm)
+
+			// instances
+			api.Post("/forms/{id}/instances", s.CreateInstanceDraft)
+			api.Get("/instances/{id}", s.GetInstance)
+			api.Put("/instances/{id}/data", s.UpdateInstanceData)
+			api.Post("/instances/{id}/submit", s.SubmitInstance)
+			api.Post("/instances/{id}/withdraw", s.WithdrawInstance)
+			api.Get("/instances", s.ListInstances)
+			api.Get("/instances/{id}/timeline", s.GetTimeline)
+			api.Get("/instances/{id}/revisions", s.ListRevisions)
+			api.Get("/instances/{id}/diff", s.GetDiff)
+
+			// tasks
+			api.Get("/tasks/inbox", s.ListInboxTasks)
+			api.Get("/tasks/done", s.ListDoneTasks)
+			api.With(s.RequireAdmin).Get("/tasks/orphaned", s.ListOrphanedTasks)
+			api.Get("/tasks/{id}", s.GetTaskDetail)
+			api.Post("/tasks/{id}/act", s.ActOnTask)
+
+			// org: everyone can read (member and department pickers),
+			// only admins change it
+			api.Get("/org/search", s.SearchOrg)
+			api.Get("/org/users", s.ListOrgUsers)
+			api.Get("/org/users/{id}", s.GetOrgUser)
+			api.Get("/org/depts", s.ListOrgDepts)
+			api.Get("/org/depts/{id}", s.GetOrgDept)
+			api.Get("/org/roles", s.ListOrgRoles)
+			api.Get("/org/{kind}/{id}/members", s.ListOrgMembers)
+			api.Group(func(api chi.Router) {
+				api.Use(s.RequireAdmin)
+
+				api.Post("/org/import", s.ImportOrg)
+				api.Post("/org/users", s.CreateOrgUser)
+				api.Put("/org/users/{id}", s.UpdateOrgUser)
+				api.Delete("/org/users/{id}", s.DeleteOrgUser)
+				api.Post("/org/depts", s.CreateOrgDept)
+				api.Put("/org/depts/{id}", s.UpdateOrgDept)
+				api.Delete("/org/depts/{id}", s.DeleteOrgDept)
+				api.Post("/org/roles", s.CreateOrgRole)
+				api.Put("/org/roles/{id}", s.UpdateOrgRole)
+				api.Delete("/org/roles/{id}", s.DeleteOrgRole)
+				api.Put("/org/{kind}/{id}/members/{userId}", s.AddOrgMember)
+				api.Delete("/org/{kind}/{id}/members/{userId}", s.RemoveOrgMember)
+			})
+		})
 	})
 
 	log.Println("✅ backend on :3001 (sqlite:", dbPath, ")")
@@ -74,3 +138,13 @@ func getenv(k, def string) string {
 	}
 	return v
 }
+
+// sqliteDSN makes writers wait for each other instead of failing with
+// SQLITE_BUSY: transactions take the write lock up front (BEGIN IMMEDIATE)
+// and block up to 5s for it.
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "?") {
+		return path
+	}
+	return path + "?_pragma=busy_timeout(5000)&_txlock=immediate"
+}
@@ -20,8 +20,8 @@ func migrate(db *sql.DB) error {
 			id TEXT PRIMARY KEY,
 			form_id TEXT NOT NULL,
 			form_version INTEGER NOT NULL,
-			status TEXT NOT NULL,       -- DRAFT|RUNNING|APPROVED|REJECTED
-			current_node TEXT NOT NULL, -- start/manager/hr/end
+			status TEXT NOT NULL,       -- DRAFT|RUNNING|APPROVED|REJECTED|WITHDRAWN
+			current_node TEXT NOT NULL, -- nodes of the active tokens, comma separated; end when finished
 			data_json TEXT NOT NULL,
 			applicant_user_id TEXT NOT NULL,
 			created_at INTEGER NOT NULL,
@@ -33,7 +33,7 @@ func migrate(db *sql.DB) error {
 			id TEXT PRIMARY KEY,
 			instance_id TEXT NOT NULL,
 			node_id TEXT NOT NULL,
-			mode TEXT NOT NULL,           -- AND|OR
+			mode TEXT NOT NULL,           -- AND|OR|SEQ
 			status TEXT NOT NULL,         -- OPEN|CLOSED
 			total_count INTEGER NOT NULL,
 			approved_count INTEGER NOT NULL,
@@ -48,10 +48,10 @@ func migrate(db *sql.DB) error {
 			group_id TEXT,
 			instance_id TEXT NOT NULL,
 			node_id TEXT NOT NULL,
-			status TEXT NOT NULL, -- PENDING|DONE
+			status TEXT NOT NULL, -- PENDING|WAITING|DONE (WAITING: suspended for add-signers)
 			assignee_type TEXT NOT NULL, -- user|role|dept
 			assignee_id TEXT NOT NULL,
-			action_taken TEXT, -- approve|reject|return|submit|auto_closed
+			action_taken TEXT, -- approve|reject|return|submit|auto_approved|auto_closed|no_assignee|transferred|withdrawn
 			actor_user_id TEXT,
 			comment TEXT,
 			created_at INTEGER NOT NULL,
@@ -60,6 +60,44 @@ func migrate(db *sql.DB) error {
 			FOREIGN KEY(group_id) REFERENCES task_groups(id)
 		);`,
 
+		`CREATE TABLE IF NOT EXISTS instance_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			instance_id TEXT NOT NULL,
+			type TEXT NOT NULL, -- created|data_updated|submitted|task_created|approved|rejected|returned|transferred|add_signed|resumed|jumped_back|withdrawn|auto_closed|forked|joined|completed
+			node_id TEXT,
+			task_id TEXT,
+			actor_user_id TEXT,
+			comment TEXT,
+			payload_json TEXT,
+			created_at INTEGER NOT NULL,
+			FOREIGN KEY(instance_id) REFERENCES instances(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_instance_events_instance ON instance_events(instance_id, id);`,
+		`CREATE TABLE IF NOT EXISTS instance_tokens (
+			id TEXT PRIMARY KEY,
+			instance_id TEXT NOT NULL,
+			node_id TEXT NOT NULL,
+			status TEXT NOT NULL,  -- ACTIVE|WAITING|DONE|CANCELLED
+			parent_token_id TEXT,  -- token split at the enclosing parallel_fork
+			created_at INTEGER NOT NULL,
+			closed_at INTEGER,
+			FOREIGN KEY(instance_id) REFERENCES instances(id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_instance_tokens_instance ON instance_tokens(instance_id, status);`,
+		`CREATE INDEX IF NOT EXISTS idx_instance_tokens_parent ON instance_tokens(parent_token_id);`,
+
+		`CREATE TABLE IF NOT EXISTS instance_revisions (
+			instance_id TEXT NOT NULL,
+			revision INTEGER NOT NULL,
+			actor_user_id TEXT NOT NULL,
+			node_id TEXT NOT NULL,
+			patch_json TEXT NOT NULL, -- changed top-level fields, null = removed
+			data_json TEXT NOT NULL,  -- full snapshot after the write
+			created_at INTEGER NOT NULL,
+			PRIMARY KEY(instance_id, revision),
+			FOREIGN KEY(instance_id) REFERENCES instances(id)
+		);`,
+
 		`CREATE TABLE IF NOT EXISTS users (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL
@@ -81,6 +119,12 @@ func migrate(db *sql.DB) error {
 			PRIMARY KEY(user_id, role_id),
 			FOREIGN KEY(user_id) REFERENCES users(id)
 		);`,
+		`CREATE TABLE IF NOT EXISTS roles (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL
+		);`,
+		// roles used to exist only as user_roles.role_id
+		`INSERT OR IGNORE INTO roles(id,name) SELECT DISTINCT role_id, role_id FROM user_roles;`,
 	}
 
 	for _, s := range stmts {
@@ -88,5 +132,41 @@ func migrate(db *sql.DB) error {
 			return err
 		}
 	}
+
+	// columns added after the initial release
+	columns := []struct{ table, column, def string }{
+		{"instances", "submit_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"users", "password_hash", "TEXT"},
+		{"instances", "row_version", "INTEGER NOT NULL DEFAULT 0"},
+		{"task_groups", "row_version", "INTEGER NOT NULL DEFAULT 0"},
+		{"task_groups", "token_id", "TEXT"},
+		{"task_groups", "assignees_json", "TEXT"}, // resolved assignees (SEQ: in order)
+		{"task_groups", "approve_threshold", "INTEGER"},
+		{"task_groups", "reject_threshold", "INTEGER"},
+		{"depts", "parent_id", "TEXT"},
+		{"depts", "manager_user_id", "TEXT"},
+		{"users", "active", "INTEGER NOT NULL DEFAULT 1"}, // 0: deactivated by an org import
+		{"tasks", "kind", "TEXT"},                         // sign_before|sign_after for add-signers
+		{"tasks", "parent_task_id", "TEXT"},               // add-signers: the task that added them
+		{"task_groups", "resume_node_id", "TEXT"},         // return with backToMe: node to jump back to once passed
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.column, c.def); err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// addColumn adds a column to an existing table unless it is already there.
+func addColumn(db *sql.DB, table, column, def string) error {
+	var cnt int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM pragma_table_info(?) WHERE name=?`, table, column).Scan(&cnt); err != nil {
+		return err
+	}
+	if cnt > 0 {
+		return nil
+	}
+	_, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + def)
+	return err
+}
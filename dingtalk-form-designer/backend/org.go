@@ -0,0 +1,690 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+/* ---------------- users ---------------- */
+
+type OrgUser struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name"`
+	Active bool     `json:"active"`
+	Depts  []string `json:"depts"`
+	Roles  []string `json:"roles"`
+}
+
+const orgUserSelect = `
+	SELECT u.id, u.name, u.active,
+	  COALESCE((SELECT group_concat(dept_id) FROM (SELECT dept_id FROM user_depts WHERE user_id=u.id ORDER BY dept_id)),''),
+	  COALESCE((SELECT group_concat(role_id) FROM (SELECT role_id FROM user_roles WHERE user_id=u.id ORDER BY role_id)),'')
+	FROM users u`
+
+func scanOrgUser(row interface{ Scan(...any) error }) (OrgUser, error) {
+	var x OrgUser
+	var depts, roles string
+	err := row.Scan(&x.ID, &x.Name, &x.Active, &depts, &roles)
+	x.Depts, x.Roles = splitList(depts), splitList(roles)
+	return x, err
+}
+
+func (s *Server) ListOrgUsers(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query(orgUserSelect + ` ORDER BY u.id`)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []OrgUser{}
+	for rows.Next() {
+		x, err := scanOrgUser(rows)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		out = append(out, x)
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) GetOrgUser(w http.ResponseWriter, r *http.Request) {
+	u, err := scanOrgUser(s.DB.QueryRow(orgUserSelect+` WHERE u.id=?`, chi.URLParam(r, "id")))
+	if err == sql.ErrNoRows {
+		writeJSON(w, 404, map[string]any{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, u)
+}
+
+func (s *Server) CreateOrgUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeJSON(w, 400, map[string]any{"error": "name required"})
+		return
+	}
+	if req.ID == "" {
+		req.ID = newID("u")
+	}
+	var pw any
+	if req.Password != "" {
+		pw = hashPassword(req.Password)
+	}
+	res, err := s.DB.Exec(`INSERT OR IGNORE INTO users(id,name,password_hash) VALUES (?,?,?)`, req.ID, req.Name, pw)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, 409, map[string]any{"error": "user already exists: " + req.ID})
+		return
+	}
+	writeJSON(w, 200, OrgUser{ID: req.ID, Name: req.Name, Active: true, Depts: []string{}, Roles: []string{}})
+}
+
+func (s *Server) UpdateOrgUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req struct {
+		Name     *string `json:"name"`
+		Password *string `json:"password"`
+		Active   *bool   `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		writeJSON(w, 400, map[string]any{"error": "name required"})
+		return
+	}
+	if !s.exists(`SELECT COUNT(1) FROM users WHERE id=?`, id) {
+		writeJSON(w, 404, map[string]any{"error": "user not found"})
+		return
+	}
+	if req.Name != nil {
+		if _, err := s.DB.Exec(`UPDATE users SET name=? WHERE id=?`, *req.Name, id); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	if req.Active != nil {
+		if _, err := s.DB.Exec(`UPDATE users SET active=? WHERE id=?`, *req.Active, id); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	if req.Password != nil {
+		var pw any
+		if *req.Password != "" {
+			pw = hashPassword(*req.Password)
+		}
+		if _, err := s.DB.Exec(`UPDATE users SET password_hash=? WHERE id=?`, pw, id); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	s.GetOrgUser(w, r)
+}
+
+// DeleteOrgUser removes a user and their memberships. Users who still have
+// pending tasks are refused with 409 until the tasks are dealt with, and
+// departments they managed are left without a manager.
+func (s *Server) DeleteOrgUser(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var cnt int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM users WHERE id=?`, id).Scan(&cnt); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if cnt == 0 {
+		writeJSON(w, 404, map[string]any{"error": "user not found"})
+		return
+	}
+	pending, err := queryStrings(tx, `SELECT id FROM tasks WHERE status IN ('PENDING','WAITING') AND assignee_type='user' AND assignee_id=? ORDER BY created_at`, id)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if len(pending) > 0 {
+		writeJSON(w, 409, map[string]any{"error": "user still has pending tasks", "taskIds": pending})
+		return
+	}
+	// open instances keep referring to their applicant; deactivate instead
+	open, err := queryStrings(tx, `SELECT id FROM instances WHERE applicant_user_id=? AND status IN ('DRAFT','RUNNING') ORDER BY created_at`, id)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if len(open) > 0 {
+		writeJSON(w, 409, map[string]any{"error": "user is the applicant of open instances, deactivate them instead", "instanceIds": open})
+		return
+	}
+
+	for _, q := range []string{
+		`DELETE FROM user_depts WHERE user_id=?`,
+		`DELETE FROM user_roles WHERE user_id=?`,
+		`UPDATE depts SET manager_user_id=NULL WHERE manager_user_id=?`,
+		`DELETE FROM users WHERE id=?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true})
+}
+
+/* ---------------- departments ---------------- */
+
+type OrgDept struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	ParentID      string `json:"parentId,omitempty"`
+	ManagerUserID string `json:"managerUserId,omitempty"`
+	MemberCount   int    `json:"memberCount"`
+}
+
+// ListOrgDepts returns every department; parentId links them into a tree.
+func (s *Server) ListOrgDepts(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query(`
+		SELECT d.id, d.name, COALESCE(d.parent_id,''), COALESCE(d.manager_user_id,''),
+		  (SELECT COUNT(1) FROM user_depts WHERE dept_id=d.id)
+		FROM depts d
+		ORDER BY d.id`)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []OrgDept{}
+	for rows.Next() {
+		var x OrgDept
+		if err := rows.Scan(&x.ID, &x.Name, &x.ParentID, &x.ManagerUserID, &x.MemberCount); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		out = append(out, x)
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) GetOrgDept(w http.ResponseWriter, r *http.Request) {
+	var x OrgDept
+	err := s.DB.QueryRow(`
+		SELECT d.id, d.name, COALESCE(d.parent_id,''), COALESCE(d.manager_user_id,''),
+		  (SELECT COUNT(1) FROM user_depts WHERE dept_id=d.id)
+		FROM depts d WHERE d.id=?`, chi.URLParam(r, "id")).
+		Scan(&x.ID, &x.Name, &x.ParentID, &x.ManagerUserID, &x.MemberCount)
+	if err == sql.ErrNoRows {
+		writeJSON(w, 404, map[string]any{"error": "department not found"})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, x)
+}
+
+type deptReq struct {
+	ID            string  `json:"id"`
+	Name          *string `json:"name"`
+	ParentID      *string `json:"parentId"`
+	ManagerUserID *string `json:"managerUserId"`
+}
+
+func (s *Server) CreateOrgDept(w http.ResponseWriter, r *http.Request) {
+	var req deptReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		writeJSON(w, 400, map[string]any{"error": "name required"})
+		return
+	}
+	if req.ID == "" {
+		req.ID = newID("d")
+	}
+	if msg := s.checkDeptRefs(req.ID, req); msg != "" {
+		writeJSON(w, 400, map[string]any{"error": msg})
+		return
+	}
+	res, err := s.DB.Exec(`INSERT OR IGNORE INTO depts(id,name,parent_id,manager_user_id) VALUES (?,?,?,?)`,
+		req.ID, *req.Name, nullIfEmpty(deref(req.ParentID)), nullIfEmpty(deref(req.ManagerUserID)))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, 409, map[string]any{"error": "department already exists: " + req.ID})
+		return
+	}
+	writeJSON(w, 200, OrgDept{ID: req.ID, Name: *req.Name, ParentID: deref(req.ParentID), ManagerUserID: deref(req.ManagerUserID)})
+}
+
+// UpdateOrgDept changes the fields present in the body; an empty parentId
+// or managerUserId clears it.
+func (s *Server) UpdateOrgDept(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req deptReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		writeJSON(w, 400, map[string]any{"error": "name required"})
+		return
+	}
+	if !s.exists(`SELECT COUNT(1) FROM depts WHERE id=?`, id) {
+		writeJSON(w, 404, map[string]any{"error": "department not found"})
+		return
+	}
+	if msg := s.checkDeptRefs(id, req); msg != "" {
+		writeJSON(w, 400, map[string]any{"error": msg})
+		return
+	}
+
+	var sets []string
+	var args []any
+	if req.Name != nil {
+		sets, args = append(sets, "name=?"), append(args, *req.Name)
+	}
+	if req.ParentID != nil {
+		sets, args = append(sets, "parent_id=?"), append(args, nullIfEmpty(*req.ParentID))
+	}
+	if req.ManagerUserID != nil {
+		sets, args = append(sets, "manager_user_id=?"), append(args, nullIfEmpty(*req.ManagerUserID))
+	}
+	if len(sets) > 0 {
+		if _, err := s.DB.Exec(`UPDATE depts SET `+strings.Join(sets, ",")+` WHERE id=?`, append(args, id)...); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	s.GetOrgDept(w, r)
+}
+
+// checkDeptRefs validates the parent (must exist and must not be the
+// department itself or one of its descendants) and the manager.
+func (s *Server) checkDeptRefs(id string, req deptReq) string {
+	if p := deref(req.ParentID); p != "" {
+		for cur, hops := p, 0; cur != ""; hops++ {
+			if cur == id || hops > 1000 {
+				return "parent would create a cycle: " + p
+			}
+			var next string
+			if err := s.DB.QueryRow(`SELECT COALESCE(parent_id,'') FROM depts WHERE id=?`, cur).Scan(&next); err != nil {
+				return "unknown parent department: " + cur
+			}
+			cur = next
+		}
+	}
+	if m := deref(req.ManagerUserID); m != "" && !s.exists(`SELECT COUNT(1) FROM users WHERE id=?`, m) {
+		return "unknown manager user: " + m
+	}
+	return ""
+}
+
+// DeleteOrgDept refuses departments that still have sub-departments or
+// pending tasks assigned to them; memberships are removed.
+func (s *Server) DeleteOrgDept(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var found, children, pending int
+	err = tx.QueryRow(`SELECT
+		  (SELECT COUNT(1) FROM depts WHERE id=?),
+		  (SELECT COUNT(1) FROM depts WHERE parent_id=?),
+		  (SELECT COUNT(1) FROM tasks WHERE status IN ('PENDING','WAITING') AND assignee_type='dept' AND assignee_id=?)`, id, id, id).
+		Scan(&found, &children, &pending)
+	switch {
+	case err != nil:
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	case found == 0:
+		writeJSON(w, 404, map[string]any{"error": "department not found"})
+		return
+	case children > 0:
+		writeJSON(w, 409, map[string]any{"error": "department has sub-departments"})
+		return
+	case pending > 0:
+		writeJSON(w, 409, map[string]any{"error": "department still has pending tasks"})
+		return
+	}
+
+	for _, q := range []string{
+		`DELETE FROM user_depts WHERE dept_id=?`,
+		`DELETE FROM depts WHERE id=?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true})
+}
+
+/* ---------------- roles ---------------- */
+
+type OrgRole struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	MemberCount int    `json:"memberCount"`
+}
+
+func (s *Server) ListOrgRoles(w http.ResponseWriter, r *http.Request) {
+	rows, err := s.DB.Query(`
+		SELECT r.id, r.name, (SELECT COUNT(1) FROM user_roles WHERE role_id=r.id)
+		FROM roles r
+		ORDER BY r.id`)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []OrgRole{}
+	for rows.Next() {
+		var x OrgRole
+		if err := rows.Scan(&x.ID, &x.Name, &x.MemberCount); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		out = append(out, x)
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) CreateOrgRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	if req.ID == "" || strings.TrimSpace(req.Name) == "" {
+		writeJSON(w, 400, map[string]any{"error": "id/name required"})
+		return
+	}
+	res, err := s.DB.Exec(`INSERT OR IGNORE INTO roles(id,name) VALUES (?,?)`, req.ID, req.Name)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, 409, map[string]any{"error": "role already exists: " + req.ID})
+		return
+	}
+	writeJSON(w, 200, OrgRole{ID: req.ID, Name: req.Name})
+}
+
+func (s *Server) UpdateOrgRole(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		writeJSON(w, 400, map[string]any{"error": "name required"})
+		return
+	}
+	res, err := s.DB.Exec(`UPDATE roles SET name=? WHERE id=?`, req.Name, id)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, 404, map[string]any{"error": "role not found"})
+		return
+	}
+	writeJSON(w, 200, OrgRole{ID: id, Name: req.Name})
+}
+
+// DeleteOrgRole refuses roles with pending tasks; memberships are removed.
+func (s *Server) DeleteOrgRole(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var found, pending int
+	err = tx.QueryRow(`SELECT
+		  (SELECT COUNT(1) FROM roles WHERE id=?),
+		  (SELECT COUNT(1) FROM tasks WHERE status IN ('PENDING','WAITING') AND assignee_type='role' AND assignee_id=?)`, id, id).
+		Scan(&found, &pending)
+	switch {
+	case err != nil:
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	case found == 0:
+		writeJSON(w, 404, map[string]any{"error": "role not found"})
+		return
+	case pending > 0:
+		writeJSON(w, 409, map[string]any{"error": "role still has pending tasks"})
+		return
+	}
+
+	for _, q := range []string{
+		`DELETE FROM user_roles WHERE role_id=?`,
+		`DELETE FROM roles WHERE id=?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true})
+}
+
+/* ---------------- membership ---------------- */
+
+// memberTables maps the {kind} URL segment to its membership table, group
+// table and group column.
+var memberTables = map[string]struct{ table, group, column string }{
+	"depts": {"user_depts", "depts", "dept_id"},
+	"roles": {"user_roles", "roles", "role_id"},
+}
+
+// ListOrgMembers handles GET /org/{kind}/{id}/members.
+func (s *Server) ListOrgMembers(w http.ResponseWriter, r *http.Request) {
+	t, ok := memberTables[chi.URLParam(r, "kind")]
+	if !ok {
+		writeJSON(w, 404, map[string]any{"error": "not found"})
+		return
+	}
+	rows, err := s.DB.Query(`
+		SELECT u.id, u.name FROM `+t.table+` m
+		JOIN users u ON u.id=m.user_id
+		WHERE m.`+t.column+`=?
+		ORDER BY u.id`, chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []map[string]any{}
+	for rows.Next() {
+		var id, name string
+		if err := rows.Scan(&id, &name); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		out = append(out, map[string]any{"id": id, "name": name})
+	}
+	writeJSON(w, 200, out)
+}
+
+// AddOrgMember handles PUT /org/{kind}/{id}/members/{userId}; adding an
+// existing member is a no-op.
+func (s *Server) AddOrgMember(w http.ResponseWriter, r *http.Request) {
+	t, ok := memberTables[chi.URLParam(r, "kind")]
+	if !ok {
+		writeJSON(w, 404, map[string]any{"error": "not found"})
+		return
+	}
+	groupID, userID := chi.URLParam(r, "id"), chi.URLParam(r, "userId")
+	if !s.exists(`SELECT COUNT(1) FROM `+t.group+` WHERE id=?`, groupID) {
+		writeJSON(w, 404, map[string]any{"error": "unknown " + t.group + ": " + groupID})
+		return
+	}
+	if !s.exists(`SELECT COUNT(1) FROM users WHERE id=?`, userID) {
+		writeJSON(w, 404, map[string]any{"error": "unknown user: " + userID})
+		return
+	}
+	if _, err := s.DB.Exec(`INSERT OR IGNORE INTO `+t.table+`(user_id,`+t.column+`) VALUES (?,?)`, userID, groupID); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true})
+}
+
+// RemoveOrgMember handles DELETE /org/{kind}/{id}/members/{userId}. Tasks
+// already created for the member are not affected.
+func (s *Server) RemoveOrgMember(w http.ResponseWriter, r *http.Request) {
+	t, ok := memberTables[chi.URLParam(r, "kind")]
+	if !ok {
+		writeJSON(w, 404, map[string]any{"error": "not found"})
+		return
+	}
+	res, err := s.DB.Exec(`DELETE FROM `+t.table+` WHERE user_id=? AND `+t.column+`=?`, chi.URLParam(r, "userId"), chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		writeJSON(w, 404, map[string]any{"error": "not a member"})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true})
+}
+
+/* ---------------- search ---------------- */
+
+type OrgSearchHit struct {
+	Type string `json:"type"` // user|dept|role
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// SearchOrg backs the member/department pickers:
+// GET /org/search?q=<text>&type=user|dept|role&limit=<n>. Ids and names are
+// matched case-insensitively by substring; without type all kinds are
+// searched. Deactivated users are left out.
+func (s *Server) SearchOrg(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	typ := r.URL.Query().Get("type")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	sources := map[string]string{"user": "(SELECT id, name FROM users WHERE active=1)", "dept": "depts", "role": "roles"}
+	var parts []string
+	var args []any
+	like := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(q)) + "%"
+	for _, k := range []string{"user", "dept", "role"} {
+		if typ != "" && typ != k {
+			continue
+		}
+		parts = append(parts, `SELECT '`+k+`', id, name FROM `+sources[k]+
+			` WHERE lower(id) LIKE ? ESCAPE '\' OR lower(name) LIKE ? ESCAPE '\'`)
+		args = append(args, like, like)
+	}
+	if len(parts) == 0 {
+		writeJSON(w, 400, map[string]any{"error": "type must be user, dept or role"})
+		return
+	}
+
+	rows, err := s.DB.Query(`SELECT * FROM (`+strings.Join(parts, " UNION ALL ")+`) ORDER BY 1, 3, 2 LIMIT ?`, append(args, limit)...)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []OrgSearchHit{}
+	for rows.Next() {
+		var x OrgSearchHit
+		if err := rows.Scan(&x.Type, &x.ID, &x.Name); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		out = append(out, x)
+	}
+	writeJSON(w, 200, out)
+}
+
+/* ---------------- helpers ---------------- */
+
+func (s *Server) exists(q string, args ...any) bool {
+	var cnt int
+	_ = s.DB.QueryRow(q, args...).Scan(&cnt)
+	return cnt > 0
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func deref(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
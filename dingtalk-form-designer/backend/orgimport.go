@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// orgRecord is one user as read from an import file. Department paths are
+// names from the root down ("公司/研发/后端"). A nil Depts/Roles/ManagerOf
+// means the source did not say, so the existing rows are kept.
+type orgRecord struct {
+	ID        string
+	Name      string
+	Depts     [][]string
+	Roles     []string
+	ManagerOf [][]string
+}
+
+// ImportReport lists what an import changed (or would change on a dry run).
+type ImportReport struct {
+	DryRun           bool     `json:"dryRun"`
+	UsersAdded       []string `json:"usersAdded"`
+	UsersUpdated     []string `json:"usersUpdated"`
+	UsersDeactivated []string `json:"usersDeactivated"`
+	DeptsAdded       []string `json:"deptsAdded"`
+	RolesAdded       []string `json:"rolesAdded"`
+	Warnings         []string `json:"warnings"`
+}
+
+type importOptions struct {
+	DryRun            bool
+	DeactivateMissing bool // users not in the file become inactive
+}
+
+/* ---------------- CSV ---------------- */
+
+// parseOrgCSV reads a CSV with a header row. Columns (any order, unknown
+// ones ignored): id (required), name, depts, roles, manager_of. Lists are
+// separated by ";", department paths by "/".
+func parseOrgCSV(r io.Reader) ([]orgRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("csv header: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+	if _, ok := col["id"]; !ok {
+		return nil, errors.New("csv header has no id column")
+	}
+	get := func(row []string, name string) (string, bool) {
+		i, ok := col[name]
+		if !ok || i >= len(row) {
+			return "", ok
+		}
+		return strings.TrimSpace(row[i]), true
+	}
+
+	var out []orgRecord
+	for line := 2; ; line++ {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rec orgRecord
+		rec.ID, _ = get(row, "id")
+		if rec.ID == "" {
+			return nil, fmt.Errorf("csv line %d: id required", line)
+		}
+		rec.Name, _ = get(row, "name")
+		if v, ok := get(row, "depts"); ok {
+			rec.Depts = splitPaths(v)
+		}
+		if v, ok := get(row, "roles"); ok {
+			rec.Roles = splitItems(v)
+		}
+		if v, ok := get(row, "manager_of"); ok {
+			rec.ManagerOf = splitPaths(v)
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+func splitItems(s string) []string {
+	out := []string{}
+	for _, it := range strings.Split(s, ";") {
+		if it = strings.TrimSpace(it); it != "" {
+			out = append(out, it)
+		}
+	}
+	return out
+}
+
+func splitPaths(s string) [][]string {
+	out := [][]string{}
+	for _, p := range splitItems(s) {
+		var path []string
+		for _, seg := range strings.Split(p, "/") {
+			if seg = strings.TrimSpace(seg); seg != "" {
+				path = append(path, seg)
+			}
+		}
+		if len(path) > 0 {
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+/* ---------------- LDIF ---------------- */
+
+// parseOrgLDIF reads person entries from an LDIF export. uid is the user
+// id, displayName (or cn) the name, the ou components of the DN the
+// department path, and the cn of every memberOf group a role. Entries
+// without a uid (groups, OUs) are skipped.
+func parseOrgLDIF(r io.Reader) ([]orgRecord, error) {
+	entries, err := readLDIF(r)
+	if err != nil {
+		return nil, err
+	}
+	var out []orgRecord
+	for _, e := range entries {
+		uid := first(e["uid"])
+		if uid == "" {
+			continue
+		}
+		rec := orgRecord{ID: uid, Name: first(e["displayname"]), Depts: [][]string{}, Roles: []string{}}
+		if rec.Name == "" {
+			rec.Name = first(e["cn"])
+		}
+		if path := dnOUs(first(e["dn"])); len(path) > 0 {
+			rec.Depts = append(rec.Depts, path)
+		}
+		for _, g := range e["memberof"] {
+			if cn := dnAttr(g, "cn"); cn != "" {
+				rec.Roles = append(rec.Roles, cn)
+			}
+		}
+		out = append(out, rec)
+	}
+	return out, nil
+}
+
+// readLDIF returns the entries as lower-cased attribute -> values. It
+// handles comments, folded lines and base64 ("attr:: ...") values.
+func readLDIF(r io.Reader) ([]map[string][]string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var entries []map[string][]string
+	cur := map[string][]string{}
+	var lines []string
+	flush := func() error {
+		for _, l := range lines {
+			name, val, ok := strings.Cut(l, ":")
+			if !ok {
+				return fmt.Errorf("ldif: bad line %q", l)
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			if strings.HasPrefix(val, ":") {
+				b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val[1:]))
+				if err != nil {
+					return fmt.Errorf("ldif: %s: %w", name, err)
+				}
+				val = string(b)
+			}
+			cur[name] = append(cur[name], strings.TrimSpace(val))
+		}
+		if len(cur) > 0 {
+			entries = append(entries, cur)
+		}
+		cur, lines = map[string][]string{}, nil
+		return nil
+	}
+
+	for sc.Scan() {
+		l := strings.TrimRight(sc.Text(), "\r")
+		switch {
+		case l == "":
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(l, "#"):
+		case strings.HasPrefix(l, " ") && len(lines) > 0:
+			lines[len(lines)-1] += l[1:]
+		case strings.HasPrefix(strings.ToLower(l), "version:") && len(entries) == 0 && len(lines) == 0:
+		default:
+			lines = append(lines, l)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// dnOUs returns the ou values of a DN from the root down:
+// "uid=a,ou=后端,ou=研发,dc=corp" -> [研发 后端].
+func dnOUs(dn string) []string {
+	var path []string
+	for _, rdn := range strings.Split(dn, ",") {
+		k, v, ok := strings.Cut(rdn, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), "ou") {
+			path = append([]string{strings.TrimSpace(v)}, path...)
+		}
+	}
+	return path
+}
+
+func dnAttr(dn, attr string) string {
+	for _, rdn := range strings.Split(dn, ",") {
+		k, v, ok := strings.Cut(rdn, "=")
+		if ok && strings.EqualFold(strings.TrimSpace(k), attr) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+func first(vs []string) string {
+	if len(vs) == 0 {
+		return ""
+	}
+	return vs[0]
+}
+
+/* ---------------- apply ---------------- */
+
+// importOrg upserts the records in one transaction; a dry run rolls it
+// back and only returns the report.
+func importOrg(db *sql.DB, recs []orgRecord, opt importOptions) (*ImportReport, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rep := &ImportReport{DryRun: opt.DryRun, UsersAdded: []string{}, UsersUpdated: []string{},
+		UsersDeactivated: []string{}, DeptsAdded: []string{}, RolesAdded: []string{}, Warnings: []string{}}
+	deptIDs := map[string]string{} // joined path -> id
+	seen := map[string]bool{}
+
+	for _, rec := range recs {
+		if seen[rec.ID] {
+			rep.Warnings = append(rep.Warnings, "duplicate user "+rec.ID+", later row wins")
+		}
+		seen[rec.ID] = true
+
+		var name string
+		var active bool
+		err := tx.QueryRow(`SELECT name, active FROM users WHERE id=?`, rec.ID).Scan(&name, &active)
+		isNew := err == sql.ErrNoRows
+		if err != nil && !isNew {
+			return nil, err
+		}
+		if rec.Name == "" {
+			rec.Name = name
+			if rec.Name == "" {
+				rec.Name = rec.ID
+			}
+		}
+		changed := false
+		if isNew {
+			if _, err := tx.Exec(`INSERT INTO users(id,name) VALUES (?,?)`, rec.ID, rec.Name); err != nil {
+				return nil, err
+			}
+		} else if name != rec.Name || !active {
+			if _, err := tx.Exec(`UPDATE users SET name=?, active=1 WHERE id=?`, rec.Name, rec.ID); err != nil {
+				return nil, err
+			}
+			changed = true
+		}
+
+		if rec.Depts != nil {
+			var ids []string
+			for _, p := range rec.Depts {
+				id, err := ensureDeptPath(tx, p, deptIDs, rep)
+				if err != nil {
+					return nil, err
+				}
+				ids = append(ids, id)
+			}
+			c, err := replaceMemberships(tx, "user_depts", "dept_id", rec.ID, ids)
+			if err != nil {
+				return nil, err
+			}
+			changed = changed || c
+		}
+		if rec.Roles != nil {
+			for _, role := range rec.Roles {
+				res, err := tx.Exec(`INSERT OR IGNORE INTO roles(id,name) VALUES (?,?)`, role, role)
+				if err != nil {
+					return nil, err
+				}
+				if n, _ := res.RowsAffected(); n > 0 {
+					rep.RolesAdded = append(rep.RolesAdded, role)
+				}
+			}
+			c, err := replaceMemberships(tx, "user_roles", "role_id", rec.ID, rec.Roles)
+			if err != nil {
+				return nil, err
+			}
+			changed = changed || c
+		}
+		for _, p := range rec.ManagerOf {
+			id, err := ensureDeptPath(tx, p, deptIDs, rep)
+			if err != nil {
+				return nil, err
+			}
+			res, err := tx.Exec(`UPDATE depts SET manager_user_id=? WHERE id=? AND COALESCE(manager_user_id,'')<>?`, rec.ID, id, rec.ID)
+			if err != nil {
+				return nil, err
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				changed = true
+			}
+		}
+
+		switch {
+		case isNew:
+			rep.UsersAdded = append(rep.UsersAdded, rec.ID)
+		case changed:
+			rep.UsersUpdated = append(rep.UsersUpdated, rec.ID)
+		}
+	}
+
+	if opt.DeactivateMissing {
+		active, err := queryStrings(tx, `SELECT id FROM users WHERE active=1 ORDER BY id`)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range active {
+			if seen[id] {
+				continue
+			}
+			if _, err := tx.Exec(`UPDATE users SET active=0 WHERE id=?`, id); err != nil {
+				return nil, err
+			}
+			rep.UsersDeactivated = append(rep.UsersDeactivated, id)
+			var pending int
+			if err := tx.QueryRow(`SELECT COUNT(1) FROM tasks WHERE status='PENDING' AND assignee_type='user' AND assignee_id=?`, id).Scan(&pending); err != nil {
+				return nil, err
+			}
+			if pending > 0 {
+				rep.Warnings = append(rep.Warnings, fmt.Sprintf("deactivated user %s still has %d pending task(s)", id, pending))
+			}
+		}
+	}
+
+	if opt.DryRun {
+		return rep, nil
+	}
+	return rep, tx.Commit()
+}
+
+// ensureDeptPath finds each department of path by name under its parent,
+// creating the missing ones, and returns the id of the last one.
+func ensureDeptPath(tx *sql.Tx, path []string, cache map[string]string, rep *ImportReport) (string, error) {
+	parent := ""
+	for i, name := range path {
+		key := strings.Join(path[:i+1], "/")
+		if id, ok := cache[key]; ok {
+			parent = id
+			continue
+		}
+		var id string
+		err := tx.QueryRow(`SELECT id FROM depts WHERE name=? AND COALESCE(parent_id,'')=? ORDER BY id LIMIT 1`, name, parent).Scan(&id)
+		if err == sql.ErrNoRows {
+			id = newID("d")
+			if _, err := tx.Exec(`INSERT INTO depts(id,name,parent_id) VALUES (?,?,?)`, id, name, nullIfEmpty(parent)); err != nil {
+				return "", err
+			}
+			rep.DeptsAdded = append(rep.DeptsAdded, key)
+		} else if err != nil {
+			return "", err
+		}
+		cache[key] = id
+		parent = id
+	}
+	return parent, nil
+}
+
+// replaceMemberships makes userID's rows in table exactly ids and reports
+// whether anything changed.
+func replaceMemberships(tx *sql.Tx, table, column, userID string, ids []string) (bool, error) {
+	have, err := queryStrings(tx, `SELECT `+column+` FROM `+table+` WHERE user_id=? ORDER BY `+column, userID)
+	if err != nil {
+		return false, err
+	}
+	want := append([]string(nil), ids...)
+	sort.Strings(want)
+	want = dedupSorted(want)
+	if strings.Join(have, "\x00") == strings.Join(want, "\x00") {
+		return false, nil
+	}
+	if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id=?`, userID); err != nil {
+		return false, err
+	}
+	for _, id := range want {
+		if _, err := tx.Exec(`INSERT INTO `+table+`(user_id,`+column+`) VALUES (?,?)`, userID, id); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func dedupSorted(s []string) []string {
+	out := s[:0]
+	for i, v := range s {
+		if i == 0 || v != s[i-1] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func parseOrgFile(format string, r io.Reader) ([]orgRecord, error) {
+	switch format {
+	case "csv":
+		return parseOrgCSV(r)
+	case "ldif":
+		return parseOrgLDIF(r)
+	}
+	return nil, errors.New("format must be csv or ldif")
+}
+
+/* ---------------- entry points ---------------- */
+
+// ImportOrg handles POST /org/import?format=csv|ldif&dryRun=1&deactivateMissing=1
+// with the file as the request body.
+func (s *Server) ImportOrg(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	recs, err := parseOrgFile(q.Get("format"), http.MaxBytesReader(w, r.Body, 32<<20))
+	if err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	rep, err := importOrg(s.DB, recs, importOptions{
+		DryRun:            q.Get("dryRun") == "1" || q.Get("dryRun") == "true",
+		DeactivateMissing: q.Get("deactivateMissing") == "1" || q.Get("deactivateMissing") == "true",
+	})
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, rep)
+}
+
+// runImportOrg implements `backend import-org (--csv F | --ldif F) [--dry-run] [--deactivate-missing]`.
+func runImportOrg(db *sql.DB, args []string) error {
+	fs := flag.NewFlagSet("import-org", flag.ContinueOnError)
+	csvPath := fs.String("csv", "", "CSV file (header: id,name,depts,roles,manager_of)")
+	ldifPath := fs.String("ldif", "", "LDIF export")
+	dryRun := fs.Bool("dry-run", false, "report changes without writing them")
+	deactivate := fs.Bool("deactivate-missing", false, "deactivate users that are not in the file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	format, path := "csv", *csvPath
+	if *ldifPath != "" {
+		format, path = "ldif", *ldifPath
+	}
+	if path == "" || (*csvPath != "" && *ldifPath != "") {
+		return errors.New("import-org: give exactly one of --csv or --ldif")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	recs, err := parseOrgFile(format, f)
+	if err != nil {
+		return err
+	}
+	rep, err := importOrg(db, recs, importOptions{DryRun: *dryRun, DeactivateMissing: *deactivate})
+	if err != nil {
+		return err
+	}
+
+	if rep.DryRun {
+		fmt.Println("dry run, nothing written")
+	}
+	for _, l := range []struct {
+		label string
+		items []string
+	}{
+		{"users added", rep.UsersAdded},
+		{"users updated", rep.UsersUpdated},
+		{"users deactivated", rep.UsersDeactivated},
+		{"departments added", rep.DeptsAdded},
+		{"roles added", rep.RolesAdded},
+		{"warnings", rep.Warnings},
+	} {
+		fmt.Printf("%s: %d\n", l.label, len(l.items))
+		for _, it := range l.items {
+			fmt.Println("  " + it)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+)
+
+// Return (退回) can target any node the instance has passed through, not
+// only the one a return edge points at. The target gets a fresh group with
+// the assignees, mode and vote thresholds of its last visit. With backToMe
+// the group remembers the returning node: once it passes, the instance
+// jumps straight back there instead of going through the approvals in
+// between again.
+//
+// Inside a parallel branch a task only returns along its own path: to the
+// nodes its branch passed or to nodes before the fork. A target in the same
+// branch restarts just that branch, so its siblings carry on and the join
+// still waits for it; any other target stops every branch.
+
+// returnTargets lists the nodes a task may return to: start, then every node
+// the instance has had tasks at in the order first reached, except the
+// task's own node and nodes last visited by another parallel branch. Inside
+// a fork, nodes outside it only count when they lead to the task's node.
+func returnTargets(q querier, wf *Workflow, task *Task) ([]string, error) {
+	var tokID string
+	if err := q.QueryRow(`SELECT COALESCE(token_id,'') FROM task_groups WHERE id=?`, task.GroupID).Scan(&tokID); err != nil {
+		return nil, err
+	}
+	chain, err := tokenChain(q, tokID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`SELECT g.node_id, COALESCE(g.token_id,''), COALESCE(t.parent_token_id,'')
+		FROM task_groups g LEFT JOIN instance_tokens t ON t.id = g.token_id
+		WHERE g.instance_id=? AND g.node_id<>'start'
+		ORDER BY g.created_at, g.rowid`, task.InstanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []string{"start"}
+	ownPath := map[string]bool{"start": true}
+	for rows.Next() {
+		var id, groupTok, parent string
+		if err := rows.Scan(&id, &groupTok, &parent); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(out, id) {
+			out = append(out, id)
+		}
+		// the last visit counts: on this task's branch, or outside any fork
+		// and (for a task inside one) before it
+		ownPath[id] = slices.Contains(chain, groupTok) || parent == "" && (len(chain) < 2 || wf.leadsTo(id, task.NodeID))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(out, func(id string) bool { return id == task.NodeID || !ownPath[id] }), nil
+}
+
+// tokenChain lists token id and the tokens of the forks enclosing it,
+// innermost first.
+func tokenChain(q querier, id string) ([]string, error) {
+	var chain []string
+	for id != "" && !slices.Contains(chain, id) {
+		chain = append(chain, id)
+		err := q.QueryRow(`SELECT COALESCE(parent_token_id,'') FROM instance_tokens WHERE id=?`, id).Scan(&id)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return chain, nil
+}
+
+// checkReturnTarget reports why task cannot return to nodeID, or "".
+func checkReturnTarget(tx *sql.Tx, schema *FormSchema, task *Task, nodeID string) (string, error) {
+	if nodeID == task.NodeID {
+		return "cannot return to the current node", nil
+	}
+	if schema.Workflow.nodeType(nodeID) != NodeTask {
+		return "can only return to a task node: " + nodeID, nil
+	}
+	targets, err := returnTargets(tx, &schema.Workflow, task)
+	if err != nil {
+		return "", err
+	}
+	if slices.Contains(targets, nodeID) {
+		return "", nil
+	}
+	var visits int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM task_groups WHERE instance_id=? AND node_id=?`, task.InstanceID, nodeID).Scan(&visits); err != nil {
+		return "", err
+	}
+	if visits > 0 {
+		return "node " + nodeID + " is not on the path to this task", nil
+	}
+	return "node " + nodeID + " has not been reached yet", nil
+}
+
+// returnFrom sends tok back from node `from` along edge; with reopen the
+// target gets its last group again instead of the edge's assignees.
+func (e *engine) returnFrom(tok token, from string, edge Edge, reopen, backToMe bool) error {
+	target, inBranch, err := e.branchOf(tok, edge.To)
+	if err != nil {
+		return err
+	}
+	if backToMe && tok.ParentID != "" && (!inBranch || target.ID != tok.ID) {
+		return errors.New("backToMe cannot jump back across a parallel fork")
+	}
+	if inBranch {
+		// only this branch starts over
+		if err := e.cancelBelow(target.ID); err != nil {
+			return err
+		}
+		if err := e.closeGroups(target.ID); err != nil {
+			return err
+		}
+	} else {
+		if err := e.cancelAll(); err != nil {
+			return err
+		}
+		if target, err = e.newToken(from, ""); err != nil {
+			return err
+		}
+	}
+	if reopen {
+		err = e.reopenNode(target, edge.To)
+	} else {
+		err = e.move(target, edge) // usually back to start
+	}
+	if err == nil && backToMe {
+		err = e.resumeAfter(target, from)
+	}
+	return err
+}
+
+// branchOf returns the token nodeID was last visited on, and whether that
+// token is inside a fork and tok or one of its ancestors: returning there
+// stays inside tok's branch.
+func (e *engine) branchOf(tok token, nodeID string) (token, bool, error) {
+	var last token
+	err := e.tx.QueryRow(`SELECT COALESCE(g.token_id,''), COALESCE(t.parent_token_id,'')
+		FROM task_groups g LEFT JOIN instance_tokens t ON t.id = g.token_id
+		WHERE g.instance_id=? AND g.node_id=? ORDER BY g.created_at DESC, g.rowid DESC LIMIT 1`, e.inst.ID, nodeID).
+		Scan(&last.ID, &last.ParentID)
+	if err == sql.ErrNoRows || (err == nil && last.ParentID == "") {
+		return last, false, nil
+	}
+	if err != nil {
+		return last, false, err
+	}
+	chain, err := tokenChain(e.tx, tok.ID)
+	return last, slices.Contains(chain, last.ID), err
+}
+
+// cancelBelow stops every branch forked off below token id.
+func (e *engine) cancelBelow(id string) error {
+	ids, err := queryStrings(e.tx, `WITH RECURSIVE below(id) AS (
+			SELECT id FROM instance_tokens WHERE parent_token_id=?
+			UNION SELECT t.id FROM instance_tokens t JOIN below b ON t.parent_token_id = b.id)
+		SELECT id FROM instance_tokens WHERE id IN below AND status IN ('ACTIVE','WAITING')`, id)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := e.cancelToken(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reopenNode moves tok to nodeID and recreates the node's last group: same
+// resolved assignees, mode and vote thresholds. A node without an earlier
+// group (start right after submit) goes to the applicant.
+func (e *engine) reopenNode(tok token, nodeID string) error {
+	if err := e.setToken(tok.ID, nodeID, "ACTIVE"); err != nil {
+		return err
+	}
+	mode, approveAt, rejectAt := "OR", 0, 0
+	var queueJSON string
+	err := e.tx.QueryRow(`SELECT mode, COALESCE(approve_threshold,0), COALESCE(reject_threshold,0), COALESCE(assignees_json,'')
+		FROM task_groups WHERE instance_id=? AND node_id=? ORDER BY created_at DESC, rowid DESC LIMIT 1`, e.inst.ID, nodeID).
+		Scan(&mode, &approveAt, &rejectAt, &queueJSON)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	var resolved []Assignee
+	switch {
+	case err == sql.ErrNoRows:
+		resolved = []Assignee{{Type: "user", ID: e.inst.ApplicantUserID}}
+	case queueJSON != "":
+		if err := json.Unmarshal([]byte(queueJSON), &resolved); err != nil {
+			return err
+		}
+	default:
+		// groups created before assignees were kept: take their own tasks
+		rows, err := e.tx.Query(`SELECT assignee_type, assignee_id FROM tasks
+			WHERE instance_id=? AND node_id=? AND kind IS NULL AND COALESCE(action_taken,'')<>'transferred'
+			  AND group_id=(SELECT id FROM task_groups WHERE instance_id=? AND node_id=? ORDER BY created_at DESC, rowid DESC LIMIT 1)
+			ORDER BY created_at, rowid`, e.inst.ID, nodeID, e.inst.ID, nodeID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a Assignee
+			if err := rows.Scan(&a.Type, &a.ID); err != nil {
+				return err
+			}
+			resolved = append(resolved, a)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	if approveAt == 0 || rejectAt == 0 {
+		approveAt, rejectAt = voteThresholds(nil, mode, len(resolved))
+	}
+	_, err = openGroup(e.tx, e.inst.ID, nodeID, mode, resolved, approveAt, rejectAt, tok.ID, e.now)
+	return err
+}
+
+// resumeAfter marks the open groups of tok so that passing them jumps back
+// to nodeID.
+func (e *engine) resumeAfter(tok token, nodeID string) error {
+	_, err := e.tx.Exec(`UPDATE task_groups SET resume_node_id=? WHERE token_id=? AND status='OPEN'`, nodeID, tok.ID)
+	return err
+}
+
+// jumpBack sends tok from a passed node straight back to the node that
+// returned to it.
+func (e *engine) jumpBack(tok token, from, to string) error {
+	if err := recordEvent(e.tx, InstanceEvent{InstanceID: e.inst.ID, Type: "jumped_back", NodeID: to,
+		Payload: map[string]any{"from": from}}, e.now); err != nil {
+		return err
+	}
+	return e.reopenNode(tok, to)
+}
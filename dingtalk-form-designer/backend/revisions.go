@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// saveRevision stores a snapshot of data when it differs from the latest
+// revision. The stored patch holds only the top-level fields that changed
+// (including recalculated ones); removed fields map to null.
+func saveRevision(tx *sql.Tx, instID, actorID, nodeID string, data map[string]any, now int64) error {
+	var rev int
+	var prevJSON string
+	err := tx.QueryRow(`SELECT revision, data_json FROM instance_revisions WHERE instance_id=? ORDER BY revision DESC LIMIT 1`, instID).
+		Scan(&rev, &prevJSON)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	prev := map[string]any{}
+	if prevJSON != "" {
+		_ = json.Unmarshal([]byte(prevJSON), &prev)
+	}
+
+	// compare through JSON so numbers etc. have the same representation
+	cur := map[string]any{}
+	b, _ := json.Marshal(data)
+	_ = json.Unmarshal(b, &cur)
+
+	patch := map[string]any{}
+	for k, v := range cur {
+		if pv, ok := prev[k]; !ok || !reflect.DeepEqual(pv, v) {
+			patch[k] = v
+		}
+	}
+	for k := range prev {
+		if _, ok := cur[k]; !ok {
+			patch[k] = nil
+		}
+	}
+	if rev > 0 && len(patch) == 0 {
+		return nil
+	}
+
+	pb, _ := json.Marshal(patch)
+	_, err = tx.Exec(`INSERT INTO instance_revisions(instance_id,revision,actor_user_id,node_id,patch_json,data_json,created_at)
+		VALUES (?,?,?,?,?,?,?)`, instID, rev+1, actorID, nodeID, string(pb), string(b), now)
+	return err
+}
+
+type RevisionRow struct {
+	Revision    int            `json:"revision"`
+	ActorUserID string         `json:"actorUserId"`
+	ActorName   string         `json:"actorName"`
+	NodeID      string         `json:"nodeId"`
+	Patch       map[string]any `json:"patch"`
+	CreatedAt   int64          `json:"createdAt"`
+}
+
+func (s *Server) ListRevisions(w http.ResponseWriter, r *http.Request) {
+	instID := chi.URLParam(r, "id")
+	inst, schema, err := s.loadInstanceWithSchema(instID)
+	if err != nil {
+		writeJSON(w, 404, map[string]any{"error": err.Error()})
+		return
+	}
+	visible, err := s.viewerVisibility(schema, inst, currentUserID(r))
+	if err != nil {
+		writeViewError(w, err)
+		return
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT r.revision, r.actor_user_id, COALESCE(u.name,''), r.node_id, r.patch_json, r.created_at
+		FROM instance_revisions r
+		LEFT JOIN users u ON u.id = r.actor_user_id
+		WHERE r.instance_id=?
+		ORDER BY r.revision`, instID)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []RevisionRow{}
+	for rows.Next() {
+		var x RevisionRow
+		var patchJSON string
+		if err := rows.Scan(&x.Revision, &x.ActorUserID, &x.ActorName, &x.NodeID, &patchJSON, &x.CreatedAt); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		x.Patch = map[string]any{}
+		_ = json.Unmarshal([]byte(patchJSON), &x.Patch)
+		if visible != nil {
+			x.Patch = stripHidden(x.Patch, visible)
+		}
+		out = append(out, x)
+	}
+	writeJSON(w, 200, out)
+}
+
+// FieldDiff describes how one field changed between two revisions.
+type FieldDiff struct {
+	FieldID string    `json:"fieldId"`
+	Change  string    `json:"change"` // added|removed|changed
+	Before  any       `json:"before"`
+	After   any       `json:"after"`
+	Rows    []RowDiff `json:"rows,omitempty"` // subtables only
+}
+
+type RowDiff struct {
+	Row     int      `json:"row"`
+	Change  string   `json:"change"` // added|removed|changed
+	Before  any      `json:"before"`
+	After   any      `json:"after"`
+	Columns []string `json:"columns,omitempty"` // changed columns
+}
+
+// GetDiff compares two revisions: ?from=<rev>&to=<rev>. `to` defaults to the
+// latest revision, `from` to the one before it; from=0 is the empty form.
+func (s *Server) GetDiff(w http.ResponseWriter, r *http.Request) {
+	instID := chi.URLParam(r, "id")
+	inst, schema, err := s.loadInstanceWithSchema(instID)
+	if err != nil {
+		writeJSON(w, 404, map[string]any{"error": err.Error()})
+		return
+	}
+
+	var latest int
+	if err := s.DB.QueryRow(`SELECT COALESCE(MAX(revision),0) FROM instance_revisions WHERE instance_id=?`, instID).Scan(&latest); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	to, err := revParam(r, "to", latest)
+	if err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	from, err := revParam(r, "from", to-1)
+	if err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	if from < 0 || to > latest || from > to {
+		writeJSON(w, 400, map[string]any{"error": "revision out of range (latest " + strconv.Itoa(latest) + ")"})
+		return
+	}
+
+	before, err := s.revisionData(instID, from)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	after, err := s.revisionData(instID, to)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	visible, err := s.viewerVisibility(schema, inst, currentUserID(r))
+	if err != nil {
+		writeViewError(w, err)
+		return
+	}
+	if visible != nil {
+		before = stripHidden(before, visible)
+		after = stripHidden(after, visible)
+	}
+
+	writeJSON(w, 200, map[string]any{"from": from, "to": to, "fields": diffData(schema, before, after)})
+}
+
+func revParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return max(def, 0), nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *Server) revisionData(instID string, rev int) (map[string]any, error) {
+	data := map[string]any{}
+	if rev == 0 {
+		return data, nil
+	}
+	var dataJSON string
+	if err := s.DB.QueryRow(`SELECT data_json FROM instance_revisions WHERE instance_id=? AND revision=?`, instID, rev).Scan(&dataJSON); err != nil {
+		return nil, err
+	}
+	_ = json.Unmarshal([]byte(dataJSON), &data)
+	return data, nil
+}
+
+// viewerVisibility returns nil for the applicant (sees everything). Anyone
+// else sees the fields the policies of their own nodes show, whatever node
+// the instance is at now, and gets errNotParticipant without any.
+func (s *Server) viewerVisibility(schema *FormSchema, inst *Instance, viewerID string) (map[string]bool, error) {
+	if viewerID == inst.ApplicantUserID {
+		return nil, nil
+	}
+	nodes, err := s.viewerNodes(inst, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return s.visibilityUnion(schema, inst, nodes)
+}
+
+// diffData lists changed fields in schema order, then unknown keys sorted.
+func diffData(schema *FormSchema, before, after map[string]any) []FieldDiff {
+	var keys []string
+	seen := map[string]bool{}
+	subtables := map[string]bool{}
+	for _, f := range schema.Fields {
+		keys = append(keys, f.ID)
+		seen[f.ID] = true
+		if f.Type == "subtable" {
+			subtables[f.ID] = true
+		}
+	}
+	var extra []string
+	for _, m := range []map[string]any{before, after} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				extra = append(extra, k)
+			}
+		}
+	}
+	sort.Strings(extra)
+	keys = append(keys, extra...)
+
+	out := []FieldDiff{}
+	for _, k := range keys {
+		bv, inBefore := before[k]
+		av, inAfter := after[k]
+		if !inBefore && !inAfter || reflect.DeepEqual(bv, av) {
+			continue
+		}
+		d := FieldDiff{FieldID: k, Change: "changed", Before: bv, After: av}
+		if !inBefore {
+			d.Change = "added"
+		} else if !inAfter {
+			d.Change = "removed"
+		}
+		if subtables[k] {
+			d.Rows = diffRows(bv, av)
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// diffRows compares subtable rows by position.
+func diffRows(before, after any) []RowDiff {
+	br, _ := before.([]any)
+	ar, _ := after.([]any)
+	var out []RowDiff
+	for i := 0; i < max(len(br), len(ar)); i++ {
+		switch {
+		case i >= len(br):
+			out = append(out, RowDiff{Row: i, Change: "added", After: ar[i]})
+		case i >= len(ar):
+			out = append(out, RowDiff{Row: i, Change: "removed", Before: br[i]})
+		case !reflect.DeepEqual(br[i], ar[i]):
+			d := RowDiff{Row: i, Change: "changed", Before: br[i], After: ar[i]}
+			bm, _ := br[i].(map[string]any)
+			am, _ := ar[i].(map[string]any)
+			cols := map[string]bool{}
+			for c, v := range bm {
+				if !reflect.DeepEqual(v, am[c]) {
+					cols[c] = true
+				}
+			}
+			for c, v := range am {
+				if !reflect.DeepEqual(v, bm[c]) {
+					cols[c] = true
+				}
+			}
+			for c := range cols {
+				d.Columns = append(d.Columns, c)
+			}
+			sort.Strings(d.Columns)
+			out = append(out, d)
+		}
+	}
+	return out
+}
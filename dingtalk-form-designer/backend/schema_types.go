@@ -1,24 +1,28 @@
 package main
 
 type FormSchema struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Version     int       `json:"version"`
-	Fields      []Field   `json:"fields"`
-	Workflow    Workflow  `json:"workflow"`
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Version      int      `json:"version"`
+	Fields       []Field  `json:"fields"`
+	Workflow     Workflow `json:"workflow"`
 	Calculations []Calc   `json:"calculations,omitempty"`
 }
 
 type Field struct {
-	ID        string   `json:"id"`
-	Type      string   `json:"type"`
-	Label     string   `json:"label"`
-	Required  bool     `json:"required,omitempty"`
-	Readonly  bool     `json:"readonly,omitempty"`
-	VisibleWhen any    `json:"visibleWhen,omitempty"`
-	Options   []string `json:"options,omitempty"`
-	Columns   []Field  `json:"columns,omitempty"` // subtable
-	MaxRows   int      `json:"maxRows,omitempty"`
+	ID           string   `json:"id"`
+	Type         string   `json:"type"`
+	Label        string   `json:"label"`
+	Required     bool     `json:"required,omitempty"`
+	Readonly     bool     `json:"readonly,omitempty"`
+	VisibleWhen  any      `json:"visibleWhen,omitempty"`
+	Options      []string `json:"options,omitempty"`
+	Columns      []Field  `json:"columns,omitempty"` // subtable
+	MaxRows      int      `json:"maxRows,omitempty"`
+	Min          *float64 `json:"min,omitempty"`  // number|money
+	Max          *float64 `json:"max,omitempty"`  // number|money
+	Step         float64  `json:"step,omitempty"` // number|money
+	DefaultValue any      `json:"defaultValue,omitempty"`
 }
 
 type Calc struct {
@@ -30,25 +34,57 @@ type Workflow struct {
 	Nodes    []Node                `json:"nodes"`
 	Edges    []Edge                `json:"edges,omitempty"`
 	Policies map[string]NodePolicy `json:"policies"`
+	Withdraw *WithdrawRule         `json:"withdraw,omitempty"`
+
+	AutoApprove *AutoApproveRule `json:"autoApprove,omitempty"`
+}
+
+// WithdrawRule says when the applicant may withdraw (撤回) a running
+// instance and what happens to it.
+type WithdrawRule struct {
+	When string `json:"when,omitempty"` // never|before_approval (default)|before_end
+	To   string `json:"to,omitempty"`   // draft (default: back to start for editing)|withdrawn (terminal)
+}
+
+// AutoApproveRule drops redundant tasks (审批人去重): they are closed as
+// auto_approved as soon as they are created.
+type AutoApproveRule struct {
+	Applicant bool   `json:"applicant,omitempty"` // the approver is the applicant
+	Repeated  string `json:"repeated,omitempty"`  // adjacent|any: the approver already approved the previous node / any node since submit
 }
 
 type Node struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`     // task|parallel_fork|parallel_join|exclusive|end (default task, "end" for the end node)
+	JoinMode string `json:"joinMode,omitempty"` // parallel_join: all|any (default all)
+
+	DisallowTransfer bool   `json:"disallowTransfer,omitempty"` // task: approvers cannot hand their task to someone else
+	OnEmptyAssignee  string `json:"onEmptyAssignee,omitempty"`  // task: block (default)|auto_pass|admin, when nobody can approve
+	AdminUserID      string `json:"adminUserId,omitempty"`      // onEmptyAssignee admin: who gets the task instead
 }
 
 type Edge struct {
 	From      string     `json:"from"`
 	To        string     `json:"to"`
-	On        string     `json:"on"`               // submit|approve|reject|return
-	Mode      string     `json:"mode"`             // AND|OR (default OR)
+	On        string     `json:"on"`   // submit|approve|reject|return; empty when leaving a gateway
+	Mode      string     `json:"mode"` // AND|OR|SEQ (default OR); SEQ approves one by one in assignee order
 	Assignees []Assignee `json:"assignees,omitempty"`
-	Condition any        `json:"condition,omitempty"` // json-logic subset
+	Condition any        `json:"condition,omitempty"` // JsonLogic
+	Priority  int        `json:"priority,omitempty"`  // alternatives are tried in ascending priority
+	IsDefault bool       `json:"isDefault,omitempty"` // taken when no other alternative matches
+	Vote      *VoteRule  `json:"vote,omitempty"`      // countersign voting; default by mode
 }
 
+// Assignee IDs by type: user id, role id, dept id, "" for applicant and
+// manager, a department field id for dept_head, a member or department field
+// id for field, and the role that ends the walk for manager_chain_until.
 type Assignee struct {
-	Type string `json:"type"` // user|role|dept|applicant
-	ID   string `json:"id"`
+	Type     string    `json:"type"` // user|role|dept|applicant|manager|dept_head|manager_chain_until|field
+	ID       string    `json:"id"`
+	Expand   bool      `json:"expand,omitempty"`   // role|dept|department field: one task per member, snapshotted at creation
+	Level    int       `json:"level,omitempty"`    // manager: 1 = direct manager (default)
+	Fallback *Assignee `json:"fallback,omitempty"` // used when this one resolves to nobody
 }
 
 type NodePolicy struct {
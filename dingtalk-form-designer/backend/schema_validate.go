@@ -0,0 +1,457 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// SchemaIssue is a single structural problem found in a FormSchema.
+// Path is a JSON path into the schema, e.g. "$.workflow.edges[2].to".
+// Warnings do not prevent saving.
+type SchemaIssue struct {
+	Path    string `json:"path"`
+	Level   string `json:"level"` // error|warning
+	Message string `json:"message"`
+}
+
+func schemaErrors(issues []SchemaIssue) []SchemaIssue {
+	var out []SchemaIssue
+	for _, is := range issues {
+		if is.Level == "error" {
+			out = append(out, is)
+		}
+	}
+	return out
+}
+
+var validEdgeOn = map[string]bool{"submit": true, "approve": true, "reject": true, "return": true}
+var validEdgeMode = map[string]bool{"": true, "AND": true, "OR": true, "SEQ": true}
+var validNodeType = map[string]bool{"": true, NodeTask: true, NodeParallelFork: true, NodeParallelJoin: true, NodeExclusive: true, NodeEnd: true}
+var validJoinMode = map[string]bool{"": true, "all": true, "any": true}
+var validOnEmpty = map[string]bool{"": true, "block": true, "auto_pass": true, "admin": true}
+
+var (
+	validWithdrawWhen = map[string]bool{"": true, "never": true, "before_approval": true, "before_end": true}
+	validWithdrawTo   = map[string]bool{"": true, "draft": true, "withdrawn": true}
+	validRepeated     = map[string]bool{"": true, "adjacent": true, "any": true}
+)
+
+func validateSchema(schema *FormSchema) []SchemaIssue {
+	var issues []SchemaIssue
+	add := func(path, format string, args ...any) {
+		issues = append(issues, SchemaIssue{Path: path, Level: "error", Message: fmt.Sprintf(format, args...)})
+	}
+	warn := func(path, format string, args ...any) {
+		issues = append(issues, SchemaIssue{Path: path, Level: "warning", Message: fmt.Sprintf(format, args...)})
+	}
+
+	if schema.ID == "" {
+		add("$.id", "id required")
+	}
+	if schema.Name == "" {
+		add("$.name", "name required")
+	}
+
+	// fields: unique ids at top level and inside each subtable
+	fields := map[string]Field{}
+	for i, f := range schema.Fields {
+		p := fmt.Sprintf("$.fields[%d]", i)
+		if f.ID == "" {
+			add(p+".id", "field id required")
+			continue
+		}
+		if _, dup := fields[f.ID]; dup {
+			add(p+".id", "duplicate field id: %s", f.ID)
+			continue
+		}
+		fields[f.ID] = f
+		if _, ok := fieldValidators[f.Type]; !ok && f.Type != "subtable" {
+			add(p+".type", "unknown field type: %s", f.Type)
+		}
+
+		if f.Type == "subtable" {
+			cols := map[string]bool{}
+			for j, c := range f.Columns {
+				cp := fmt.Sprintf("%s.columns[%d]", p, j)
+				if c.ID == "" {
+					add(cp+".id", "column id required")
+					continue
+				}
+				if cols[c.ID] {
+					add(cp+".id", "duplicate column id in %s: %s", f.ID, c.ID)
+					continue
+				}
+				cols[c.ID] = true
+				if _, ok := fieldValidators[c.Type]; !ok {
+					add(cp+".type", "unknown column type: %s", c.Type)
+				}
+			}
+		}
+	}
+
+	// calculations
+	for i, c := range schema.Calculations {
+		p := fmt.Sprintf("$.calculations[%d]", i)
+		if _, ok := fields[c.TargetFieldId]; !ok {
+			add(p+".targetFieldId", "unknown field: %s", c.TargetFieldId)
+		}
+		if _, err := compileCalc(c.Expr); err != nil {
+			add(p+".expr", "invalid expression: %s", err.Error())
+		}
+	}
+
+	// nodes
+	wf := schema.Workflow
+	nodes := map[string]bool{}
+	for i, n := range wf.Nodes {
+		p := fmt.Sprintf("$.workflow.nodes[%d]", i)
+		if n.ID == "" {
+			add(p+".id", "node id required")
+			continue
+		}
+		if nodes[n.ID] {
+			add(p+".id", "duplicate node id: %s", n.ID)
+			continue
+		}
+		nodes[n.ID] = true
+		if !validNodeType[n.Type] {
+			add(p+".type", "invalid node type: %s", n.Type)
+		}
+		if n.ID == "start" && n.Type != "" && n.Type != NodeTask {
+			add(p+".type", "start must be a task node")
+		}
+		if n.JoinMode != "" && n.Type != NodeParallelJoin {
+			add(p+".joinMode", "joinMode only applies to parallel_join")
+		} else if !validJoinMode[n.JoinMode] {
+			add(p+".joinMode", "invalid join mode: %s", n.JoinMode)
+		}
+		if n.DisallowTransfer && schema.Workflow.nodeType(n.ID) != NodeTask {
+			add(p+".disallowTransfer", "disallowTransfer only applies to task nodes")
+		}
+		switch {
+		case !validOnEmpty[n.OnEmptyAssignee]:
+			add(p+".onEmptyAssignee", "invalid onEmptyAssignee policy: %s", n.OnEmptyAssignee)
+		case n.OnEmptyAssignee != "" && schema.Workflow.nodeType(n.ID) != NodeTask:
+			add(p+".onEmptyAssignee", "onEmptyAssignee only applies to task nodes")
+		case n.OnEmptyAssignee == "admin" && n.AdminUserID == "":
+			add(p+".adminUserId", "adminUserId required when onEmptyAssignee is admin")
+		case n.OnEmptyAssignee != "admin" && n.AdminUserID != "":
+			add(p+".adminUserId", "adminUserId only applies when onEmptyAssignee is admin")
+		}
+	}
+	if wr := schema.Workflow.Withdraw; wr != nil {
+		if !validWithdrawWhen[wr.When] {
+			add("$.workflow.withdraw.when", "invalid withdraw rule: %s", wr.When)
+		}
+		if !validWithdrawTo[wr.To] {
+			add("$.workflow.withdraw.to", "invalid withdraw target: %s", wr.To)
+		}
+	}
+	if ar := schema.Workflow.AutoApprove; ar != nil && !validRepeated[ar.Repeated] {
+		add("$.workflow.autoApprove.repeated", "invalid repeated approver rule: %s", ar.Repeated)
+	}
+	if !nodes["start"] {
+		add("$.workflow.nodes", "missing start node")
+	}
+	if !nodes["end"] {
+		add("$.workflow.nodes", "missing end node")
+	}
+
+	// edges; edges leaving a gateway carry no action, all others do
+	hasSubmit := false
+	next := map[string][]string{}
+	outCount := map[string]int{}
+	for i, e := range wf.Edges {
+		p := fmt.Sprintf("$.workflow.edges[%d]", i)
+		if !nodes[e.From] {
+			add(p+".from", "unknown node: %s", e.From)
+		}
+		if !nodes[e.To] {
+			add(p+".to", "unknown node: %s", e.To)
+		}
+		fromType, toType := wf.nodeType(e.From), wf.nodeType(e.To)
+		if isGateway(fromType) {
+			if e.On != "" {
+				add(p+".on", "edge leaving gateway %s cannot have an action", e.From)
+			}
+		} else if !validEdgeOn[e.On] {
+			add(p+".on", "invalid action: %s", e.On)
+		}
+		if !validEdgeMode[e.Mode] {
+			add(p+".mode", "invalid mode: %s", e.Mode)
+		}
+		if fromType == NodeEnd {
+			add(p+".from", "end node cannot have outgoing edges")
+		}
+		if e.From == "start" && e.On == "submit" {
+			hasSubmit = true
+		}
+		if toType == NodeTask && e.On != "reject" && len(e.Assignees) == 0 && !emptyAllowed(&schema.Workflow, e.To) {
+			add(p+".assignees", "edge into %s has no assignees", e.To)
+		}
+		outCount[e.From]++
+		if e.Vote != nil {
+			if e.Mode == "" || e.Mode == "OR" {
+				add(p+".vote", "vote rule needs mode AND or SEQ")
+			}
+			check := func(name string, t Threshold) {
+				tp := p + ".vote." + name
+				switch {
+				case t.Count < 0 || t.Percent < 0 || t.Percent > 100:
+					add(tp, "threshold out of range")
+				case t.Count > 0 && t.Percent > 0:
+					add(tp, "use either count or percent")
+				case t.Count > len(e.Assignees) && !expands(e.Assignees):
+					add(tp+".count", "count %d exceeds the %d assignees", t.Count, len(e.Assignees))
+				}
+			}
+			check("approve", e.Vote.Approve)
+			check("reject", e.Vote.Reject)
+		}
+		var checkAssignee func(ap string, a Assignee)
+		checkAssignee = func(ap string, a Assignee) {
+			if a.Expand && a.Type != "role" && a.Type != "dept" && a.Type != "field" {
+				add(ap+".expand", "expand only applies to role, dept and department field assignees")
+			}
+			if a.Level != 0 && a.Type != "manager" {
+				add(ap+".level", "level only applies to manager assignees")
+			} else if a.Level < 0 {
+				add(ap+".level", "level must be >= 1")
+			}
+			switch a.Type {
+			case "user", "role", "dept", "manager_chain_until":
+				if a.ID == "" {
+					add(ap+".id", "assignee id required for type %s", a.Type)
+				}
+			case "dept_head":
+				if f, ok := fields[a.ID]; !ok || f.Type != "department" {
+					add(ap+".id", "dept_head needs a department field: %s", a.ID)
+				}
+			case "field":
+				f, ok := fields[a.ID]
+				switch {
+				case !ok:
+					add(ap+".id", "unknown field: %s", a.ID)
+				case f.Type != "member" && f.Type != "department":
+					add(ap+".id", "field %s is a %s field, need member or department", a.ID, f.Type)
+				case a.Expand && f.Type != "department":
+					add(ap+".expand", "expand only applies to department fields")
+				}
+			case "applicant", "manager":
+			default:
+				add(ap+".type", "invalid assignee type: %s", a.Type)
+			}
+			if a.Fallback != nil {
+				checkAssignee(ap+".fallback", *a.Fallback)
+			}
+		}
+		for j, a := range e.Assignees {
+			checkAssignee(fmt.Sprintf("%s.assignees[%d]", p, j), a)
+		}
+		next[e.From] = append(next[e.From], e.To)
+	}
+	if nodes["start"] && !hasSubmit {
+		add("$.workflow.edges", "start node has no submit edge")
+	}
+	for i, n := range wf.Nodes {
+		p := fmt.Sprintf("$.workflow.nodes[%d]", i)
+		switch n.Type {
+		case NodeParallelFork:
+			if outCount[n.ID] < 2 {
+				add(p, "parallel_fork %s needs at least 2 outgoing edges", n.ID)
+			} else if branchReachesEnd(&wf, n.ID) {
+				add(p, "a branch of parallel_fork %s reaches end without a parallel_join", n.ID)
+			}
+		case NodeParallelJoin:
+			if outCount[n.ID] != 1 {
+				add(p, "parallel_join %s needs exactly 1 outgoing edge", n.ID)
+			}
+		case NodeExclusive:
+			if outCount[n.ID] == 0 {
+				add(p, "exclusive gateway %s has no outgoing edges", n.ID)
+			}
+		}
+	}
+
+	// alternatives: edges sharing from/on, picked by condition at runtime
+	type branchKey struct{ from, on string }
+	var branchKeys []branchKey
+	branches := map[branchKey][]int{}
+	for i, e := range wf.Edges {
+		k := branchKey{e.From, e.On}
+		if _, ok := branches[k]; !ok {
+			branchKeys = append(branchKeys, k)
+		}
+		branches[k] = append(branches[k], i)
+	}
+	for _, k := range branchKeys {
+		if wf.nodeType(k.from) == NodeParallelFork {
+			continue // every branch is taken
+		}
+		where := "from " + k.from
+		if k.on != "" {
+			where += " on " + k.on
+		}
+		idx := branches[k]
+		defaults, conditional, unconditional := 0, 0, 0
+		for _, i := range idx {
+			e := wf.Edges[i]
+			p := fmt.Sprintf("$.workflow.edges[%d]", i)
+			switch {
+			case e.IsDefault:
+				defaults++
+				if defaults > 1 {
+					add(p+".isDefault", "more than one default edge %s", where)
+				}
+				if e.Condition != nil {
+					warn(p+".condition", "condition of the default edge is ignored")
+				}
+			case e.Condition == nil:
+				unconditional++
+			default:
+				conditional++
+			}
+		}
+		if conditional > 0 && defaults == 0 && unconditional == 0 {
+			warn(fmt.Sprintf("$.workflow.edges[%d]", idx[0]), "no default edge %s: the action fails when no condition matches", where)
+		}
+
+		// alternatives in evaluation order; overlaps are only detected for
+		// simple numeric ranges
+		ordered := orderedAlternatives(wf.Edges, idx)
+		for a := 0; a < len(ordered); a++ {
+			ra, ok := conditionRanges(wf.Edges[ordered[a]].Condition)
+			if !ok {
+				continue
+			}
+			for b := a + 1; b < len(ordered); b++ {
+				rb, ok := conditionRanges(wf.Edges[ordered[b]].Condition)
+				if !ok {
+					continue
+				}
+				if ex := overlapExample(ra, rb); ex != "" {
+					warn(fmt.Sprintf("$.workflow.edges[%d].condition", ordered[b]),
+						"conditions of edges[%d] and edges[%d] can both be true (%s); edges[%d] is taken", ordered[a], ordered[b], ex, ordered[a])
+				}
+			}
+		}
+	}
+
+	// every node must be reachable from start
+	if nodes["start"] {
+		seen := map[string]bool{"start": true}
+		queue := []string{"start"}
+		for len(queue) > 0 {
+			cur := queue[0]
+			queue = queue[1:]
+			for _, to := range next[cur] {
+				if !seen[to] {
+					seen[to] = true
+					queue = append(queue, to)
+				}
+			}
+		}
+		for i, n := range wf.Nodes {
+			if n.ID != "" && wf.nodeType(n.ID) != NodeEnd && !seen[n.ID] {
+				add(fmt.Sprintf("$.workflow.nodes[%d]", i), "node not reachable from start: %s", n.ID)
+			}
+		}
+	}
+
+	// policies
+	policyNodes := make([]string, 0, len(wf.Policies))
+	for nodeID := range wf.Policies {
+		policyNodes = append(policyNodes, nodeID)
+	}
+	sort.Strings(policyNodes)
+	for _, nodeID := range policyNodes {
+		pol := wf.Policies[nodeID]
+		p := "$.workflow.policies." + nodeID
+		if !nodes[nodeID] {
+			add(p, "policy for unknown node: %s", nodeID)
+		}
+		check := func(name string, list []string) {
+			for i, fid := range list {
+				if fid == "*" {
+					continue
+				}
+				if _, ok := fields[fid]; !ok {
+					add(fmt.Sprintf("%s.%s[%d]", p, name, i), "unknown field: %s", fid)
+				}
+			}
+		}
+		check("visible", pol.Visible)
+		check("editable", pol.Editable)
+		check("required", pol.Required)
+	}
+
+	return issues
+}
+
+// branchReachesEnd reports whether a branch of fork can move on to end
+// before its join: the join would wait for that branch forever. Reject
+// edges are fine, a reject stops every branch.
+func branchReachesEnd(wf *Workflow, fork string) bool {
+	type at struct {
+		node  string
+		depth int // forks entered but not yet joined
+	}
+	seen := map[at]bool{}
+	queue := []at{{fork, 1}}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range wf.Edges {
+			if e.From != cur.node || e.On == "return" || e.On == "reject" {
+				continue
+			}
+			next := at{e.To, cur.depth}
+			switch wf.nodeType(e.To) {
+			case NodeEnd:
+				return true
+			case NodeParallelFork:
+				next.depth++
+			case NodeParallelJoin:
+				next.depth--
+			}
+			if next.depth == 0 || next.depth > len(wf.Nodes) || seen[next] {
+				continue
+			}
+			seen[next] = true
+			queue = append(queue, next)
+		}
+	}
+	return false
+}
+
+// orderedAlternatives returns the non-default edges of idx in the order they
+// are evaluated: ascending priority, then schema order.
+func orderedAlternatives(edges []Edge, idx []int) []int {
+	var out []int
+	for _, i := range idx {
+		if !edges[i].IsDefault {
+			out = append(out, i)
+		}
+	}
+	sort.SliceStable(out, func(a, b int) bool { return edges[out[a]].Priority < edges[out[b]].Priority })
+	return out
+}
+
+// conditionRanges is numericRanges with a missing condition meaning
+// "always true".
+func conditionRanges(cond any) (map[string]interval, bool) {
+	if cond == nil {
+		return map[string]interval{}, true
+	}
+	return numericRanges(cond)
+}
+
+// expands reports whether the number of tasks depends on org data.
+func expands(as []Assignee) bool {
+	for _, a := range as {
+		if a.Expand || a.Type == "dept_head" || a.Type == "manager_chain_until" || a.Type == "field" {
+			return true
+		}
+	}
+	return false
+}
@@ -8,10 +8,21 @@ import (
 
 func seedDemo(db *sql.DB) error {
 	// users + org
-	_, _ = db.Exec(`INSERT OR IGNORE INTO users(id,name) VALUES ('u1','Alice'),('u2','Lily'),('u3','Bob')`)
+	_, _ = db.Exec(`INSERT OR IGNORE INTO users(id,name) VALUES ('u1','Alice'),('u2','Lily'),('u3','Bob'),('u4','Admin')`)
 	_, _ = db.Exec(`INSERT OR IGNORE INTO depts(id,name) VALUES ('d1','研发'),('d2','HR')`)
 	_, _ = db.Exec(`INSERT OR IGNORE INTO user_depts(user_id,dept_id) VALUES ('u1','d1'),('u2','d2'),('u3','d1')`)
-	_, _ = db.Exec(`INSERT OR IGNORE INTO user_roles(user_id,role_id) VALUES ('u3','manager'),('u2','hr')`)
+	_, _ = db.Exec(`INSERT OR IGNORE INTO roles(id,name) VALUES ('manager','经理'),('hr','人事'),('admin','管理员')`)
+	_, _ = db.Exec(`INSERT OR IGNORE INTO user_roles(user_id,role_id) VALUES ('u3','manager'),('u2','hr'),('u4','admin')`)
+	_, _ = db.Exec(`UPDATE depts SET manager_user_id='u3' WHERE id='d1' AND manager_user_id IS NULL`)
+	_, _ = db.Exec(`UPDATE depts SET manager_user_id='u2' WHERE id='d2' AND manager_user_id IS NULL`)
+	// demo password for every seeded user: "demo"
+	for _, uid := range []string{"u1", "u2", "u3", "u4"} {
+		var hasPw int
+		_ = db.QueryRow(`SELECT COUNT(1) FROM users WHERE id=? AND password_hash IS NOT NULL`, uid).Scan(&hasPw)
+		if hasPw == 0 {
+			_, _ = db.Exec(`UPDATE users SET password_hash=? WHERE id=?`, hashPassword("demo"), uid)
+		}
+	}
 
 	// if published exists, do nothing
 	var cnt int
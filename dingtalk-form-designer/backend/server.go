@@ -5,12 +5,18 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"slices"
+	"sort"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
-type Server struct{ DB *sql.DB }
+type Server struct {
+	DB         *sql.DB
+	AuthSecret []byte // HMAC key for session tokens
+	DevMode    bool   // trust the X-Impersonate-User header
+}
 
 /* ---------------- DB models ---------------- */
 
@@ -22,6 +28,9 @@ type Instance struct {
 	CurrentNode     string
 	Data            map[string]any
 	ApplicantUserID string
+	CreatedAt       int64
+	SubmitCount     int
+	RowVersion      int
 }
 
 type Task struct {
@@ -32,6 +41,8 @@ type Task struct {
 	Status       string
 	AssigneeType string
 	AssigneeID   string
+	Kind         string // "" for workflow tasks, sign_before|sign_after for add-signers
+	ParentTaskID string // add-signers: the task that added them
 }
 
 /* ---------------- forms ---------------- */
@@ -88,6 +99,10 @@ func (s *Server) SaveForm(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, 400, map[string]any{"error": "id/name required"})
 		return
 	}
+	if issues := validateSchema(&schema); len(schemaErrors(issues)) > 0 {
+		writeJSON(w, 400, map[string]any{"error": "invalid schema", "issues": issues})
+		return
+	}
 	var maxV int
 	_ = s.DB.QueryRow(`SELECT COALESCE(MAX(version),0) FROM forms WHERE id=?`, schema.ID).Scan(&maxV)
 	schema.Version = maxV + 1
@@ -103,6 +118,19 @@ func (s *Server) SaveForm(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, 200, schema)
 }
 
+func (s *Server) ValidateForm(w http.ResponseWriter, r *http.Request) {
+	var schema FormSchema
+	if err := json.NewDecoder(r.Body).Decode(&schema); err != nil {
+		writeJSON(w, 400, map[string]any{"error": "bad json"})
+		return
+	}
+	issues := validateSchema(&schema)
+	if issues == nil {
+		issues = []SchemaIssue{}
+	}
+	writeJSON(w, 200, map[string]any{"ok": len(schemaErrors(issues)) == 0, "issues": issues})
+}
+
 func (s *Server) PublishForm(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	_, err := s.DB.Exec(`
@@ -119,21 +147,17 @@ func (s *Server) PublishForm(w http.ResponseWriter, r *http.Request) {
 /* ---------------- instances ---------------- */
 
 type CreateInstanceReq struct {
-	UserID string         `json:"userId"`
-	Data   map[string]any `json:"data"`
+	Data map[string]any `json:"data"`
 }
 
 func (s *Server) CreateInstanceDraft(w http.ResponseWriter, r *http.Request) {
 	formID := chi.URLParam(r, "id")
+	userID := currentUserID(r)
 	var req CreateInstanceReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, 400, map[string]any{"error": "bad json"})
 		return
 	}
-	if req.UserID == "" {
-		writeJSON(w, 400, map[string]any{"error": "userId required"})
-		return
-	}
 
 	var ver int
 	var sj string
@@ -146,75 +170,141 @@ func (s *Server) CreateInstanceDraft(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var schema FormSchema
+	if err := json.Unmarshal([]byte(sj), &schema); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "schema json invalid"})
+		return
+	}
+	if req.Data == nil {
+		req.Data = map[string]any{}
+	}
+	if err := rejectCalculatedWrites(&schema, req.Data); err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := applyCalculations(&schema, req.Data); err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	if errs := s.validateFieldValues(&schema, req.Data); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
+
 	instID := newID("inst")
 	now := time.Now().UnixMilli()
 	dataJSON, _ := json.Marshal(req.Data)
 
-	_, err = s.DB.Exec(`INSERT INTO instances(id,form_id,form_version,status,current_node,data_json,applicant_user_id,created_at,updated_at)
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(`INSERT INTO instances(id,form_id,form_version,status,current_node,data_json,applicant_user_id,created_at,updated_at)
 		VALUES (?,?,?,?,?,?,?,?,?)`,
-		instID, formID, ver, "DRAFT", "start", string(dataJSON), req.UserID, now, now)
+		instID, formID, ver, "DRAFT", "start", string(dataJSON), userID, now, now)
 	if err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
+	if err := recordEvent(tx, InstanceEvent{InstanceID: instID, Type: "created", NodeID: "start", ActorUserID: userID}, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := saveRevision(tx, instID, userID, "start", req.Data, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	// the root token waits at start until the first submit
+	if _, err := s.newEngine(tx, &Instance{ID: instID, CurrentNode: "start"}, &schema, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
 	writeJSON(w, 200, map[string]any{"id": instID, "status": "DRAFT", "currentNode": "start"})
 }
 
 func (s *Server) GetInstance(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	row := s.DB.QueryRow(`SELECT id,form_id,form_version,status,current_node,data_json,applicant_user_id FROM instances WHERE id=?`, id)
+	viewerID := currentUserID(r)
 
-	var inst Instance
-	var dataJSON string
-	if err := row.Scan(&inst.ID, &inst.FormID, &inst.FormVersion, &inst.Status, &inst.CurrentNode, &dataJSON, &inst.ApplicantUserID); err != nil {
+	inst, schema, err := s.loadInstanceWithSchema(id)
+	if err != nil {
 		writeJSON(w, 404, map[string]any{"error": "not found"})
 		return
 	}
-	inst.Data = map[string]any{}
-	_ = json.Unmarshal([]byte(dataJSON), &inst.Data)
 
+	// non-applicants only see what the policies of their own nodes show
+	data := inst.Data
+	visible, err := s.viewerVisibility(schema, inst, viewerID)
+	if err != nil {
+		writeViewError(w, err)
+		return
+	}
+	if visible != nil {
+		data = stripHidden(inst.Data, visible)
+	}
+
+	w.Header().Set("ETag", etag(inst.RowVersion))
 	writeJSON(w, 200, map[string]any{
 		"id":              inst.ID,
 		"formId":          inst.FormID,
 		"formVersion":     inst.FormVersion,
 		"status":          inst.Status,
 		"currentNode":     inst.CurrentNode,
-		"data":            inst.Data,
+		"activeNodes":     inst.ActiveNodes(),
+		"data":            data,
 		"applicantUserId": inst.ApplicantUserID,
+		"rowVersion":      inst.RowVersion,
 	})
 }
 
-type SubmitReq struct{ UserID string `json:"userId"` }
-
 func (s *Server) SubmitInstance(w http.ResponseWriter, r *http.Request) {
 	instID := chi.URLParam(r, "id")
-	var req SubmitReq
-	_ = json.NewDecoder(r.Body).Decode(&req)
-	if req.UserID == "" {
-		writeJSON(w, 400, map[string]any{"error": "userId required"})
-		return
-	}
+	userID := currentUserID(r)
 
 	inst, schema, err := s.loadInstanceWithSchema(instID)
 	if err != nil {
 		writeJSON(w, 404, map[string]any{"error": err.Error()})
 		return
 	}
-	if inst.ApplicantUserID != req.UserID {
+	if inst.ApplicantUserID != userID {
 		writeJSON(w, 403, map[string]any{"error": "only applicant can submit"})
 		return
 	}
-	if inst.CurrentNode != "start" || (inst.Status != "DRAFT" && inst.Status != "RUNNING") {
+	if !slices.Contains(inst.ActiveNodes(), "start") || (inst.Status != "DRAFT" && inst.Status != "RUNNING") {
 		writeJSON(w, 400, map[string]any{"error": "instance not submittable at current state"})
 		return
 	}
 
-	if err := validateRequired(schema, "start", inst.Data); err != nil {
+	if err := applyCalculations(schema, inst.Data); err != nil {
 		writeJSON(w, 400, map[string]any{"error": err.Error()})
 		return
 	}
+	visible, err := s.visibilityAt(schema, inst, "start")
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if errs := append(validateRequired(schema, "start", inst.Data, visible), s.validateFieldValues(schema, inst.Data)...); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return
+	}
 
-	edge, ok, err := findEdgeByCondition(schema, "start", "submit", inst.Data)
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().UnixMilli()
+
+	inst.SubmitCount++
+	edge, ok, err := s.findEdgeByCondition(tx, schema, inst, "start", "submit")
 	if err != nil {
 		writeJSON(w, 400, map[string]any{"error": err.Error()})
 		return
@@ -224,28 +314,90 @@ func (s *Server) SubmitInstance(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tx, _ := s.DB.Begin()
-	defer tx.Rollback()
-	now := time.Now().UnixMilli()
-
-	// DRAFT/RUNNING -> RUNNING, node -> edge.To
-	if _, err := tx.Exec(`UPDATE instances SET status='RUNNING', current_node=?, updated_at=? WHERE id=?`,
-		edge.To, now, inst.ID); err != nil {
+	eng, err := s.newEngine(tx, inst, schema, now)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := saveRevision(tx, inst.ID, userID, "start", inst.Data, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	// a resubmit after return completes the applicant's start task; a
+	// return with backToMe goes straight back to the returning node
+	var resumeNode string
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(resume_node_id),'') FROM task_groups WHERE instance_id=? AND node_id='start' AND status='OPEN'`,
+		inst.ID).Scan(&resumeNode); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	nextTo := edge.To
+	if resumeNode != "" {
+		nextTo = resumeNode
+	}
+	if _, err := tx.Exec(`UPDATE tasks SET status='DONE', action_taken='submit', actor_user_id=?, completed_at=?
+		WHERE instance_id=? AND node_id='start' AND status='PENDING'`, userID, now, inst.ID); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(`UPDATE task_groups SET status='CLOSED', closed_at=?, row_version=row_version+1 WHERE instance_id=? AND node_id='start' AND status='OPEN'`,
+		now, inst.ID); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "submitted", NodeID: "start", ActorUserID: userID,
+		Payload: map[string]any{"submitCount": inst.SubmitCount, "nextNode": nextTo}}, now); err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
 
-	// create next node tasks
-	if _, err := s.createNodeTasks(tx, inst, edge.To, edge, now); err != nil {
+	// move the start token on, creating the next node tasks
+	tok, found, err := eng.activeTokenAt("start")
+	if err == nil && !found {
+		tok, err = eng.newToken("start", "")
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if resumeNode != "" {
+		err = eng.jumpBack(tok, "start", resumeNode)
+	} else {
+		err = eng.move(tok, edge)
+	}
+	if err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return
+	}
+	nextNode, err := eng.currentNode()
+	if err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
+	status := "RUNNING"
+	if nextNode == "end" {
+		status = "APPROVED"
+		if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "completed", NodeID: "end",
+			Payload: map[string]any{"status": status}}, now); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+
+	// DRAFT/RUNNING -> RUNNING, node -> active nodes
+	dataJSON, _ := json.Marshal(inst.Data)
+	if err := execVersioned(tx, `UPDATE instances SET status=?, current_node=?, data_json=?, submit_count=?, updated_at=?, row_version=row_version+1
+		WHERE id=? AND row_version=?`,
+		status, nextNode, string(dataJSON), inst.SubmitCount, now, inst.ID, inst.RowVersion); err != nil {
+		writeTxError(w, err)
+		return
+	}
 
 	if err := tx.Commit(); err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
-	writeJSON(w, 200, map[string]any{"ok": true, "instanceId": inst.ID, "nextNode": edge.To})
+	writeJSON(w, 200, map[string]any{"ok": true, "instanceId": inst.ID, "nextNode": nextNode})
 }
 
 /* ---------------- tasks ---------------- */
@@ -270,22 +422,18 @@ type InboxTaskRow struct {
 }
 
 func (s *Server) ListInboxTasks(w http.ResponseWriter, r *http.Request) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		writeJSON(w, 400, map[string]any{"error": "userId required"})
-		return
-	}
+	userID := currentUserID(r)
 
 	rows, err := s.DB.Query(`
 		SELECT
 		  t.id, t.node_id, t.status, t.assignee_type, t.assignee_id, t.created_at,
 		  i.id, i.status, i.current_node, i.applicant_user_id,
-		  u.name,
+		  COALESCE(u.name,''),
 		  f.id, f.name, i.form_version
 		FROM tasks t
 		JOIN instances i ON i.id = t.instance_id
 		JOIN forms f ON f.id = i.form_id AND f.version = i.form_version
-		JOIN users u ON u.id = i.applicant_user_id
+		LEFT JOIN users u ON u.id = i.applicant_user_id
 		WHERE t.status='PENDING'
 		AND (
 		 (t.assignee_type='user' AND t.assignee_id=?)
@@ -320,21 +468,59 @@ func (s *Server) ListInboxTasks(w http.ResponseWriter, r *http.Request) {
 }
 
 type ActReq struct {
-	UserID    string         `json:"userId"`
-	Action    string         `json:"action"` // approve|reject|return
-	Comment   string         `json:"comment"`
-	DataPatch map[string]any `json:"dataPatch"`
+	Action     string         `json:"action"` // approve|reject|return|transfer|add_sign_before|add_sign_after
+	Comment    string         `json:"comment"`
+	DataPatch  map[string]any `json:"dataPatch"`
+	TransferTo string         `json:"transferTo"` // transfer: user id
+	Signers    []string       `json:"signers"`    // add_sign_*: user ids
+
+	TargetNodeID string `json:"targetNodeId"` // return: any node reached so far (default: the return edge)
+	BackToMe     bool   `json:"backToMe"`     // return: jump straight back here once the target passes
+}
+
+// applyTaskPatch merges patch into inst.Data, recalculates, and checks the
+// result against the visibility and required fields of nodeID. It writes the
+// error response and returns false when the patch is rejected.
+func (s *Server) applyTaskPatch(w http.ResponseWriter, schema *FormSchema, inst *Instance, nodeID string, patch map[string]any) bool {
+	for k, v := range patch {
+		inst.Data[k] = v
+	}
+	if err := applyCalculations(schema, inst.Data); err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return false
+	}
+	visible, err := s.visibilityAt(schema, inst, nodeID)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return false
+	}
+	if err := enforceVisible(visible, nodeID, patch); err != nil {
+		writeJSON(w, 400, map[string]any{"error": err.Error()})
+		return false
+	}
+	if errs := append(validateRequired(schema, nodeID, inst.Data, visible), s.validateFieldValues(schema, inst.Data)...); len(errs) > 0 {
+		writeFieldErrors(w, errs)
+		return false
+	}
+	return true
 }
 
 func (s *Server) ActOnTask(w http.ResponseWriter, r *http.Request) {
 	taskID := chi.URLParam(r, "id")
+	userID := currentUserID(r)
 	var req ActReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		writeJSON(w, 400, map[string]any{"error": "bad json"})
 		return
 	}
-	if req.UserID == "" || (req.Action != "approve" && req.Action != "reject" && req.Action != "return") {
-		writeJSON(w, 400, map[string]any{"error": "userId + action(approve|reject|return) required"})
+	switch req.Action {
+	case "approve", "reject", "return", "transfer", "add_sign_before", "add_sign_after":
+	default:
+		writeJSON(w, 400, map[string]any{"error": "action(approve|reject|return|transfer|add_sign_before|add_sign_after) required"})
+		return
+	}
+	if (req.TargetNodeID != "" || req.BackToMe) && req.Action != "return" {
+		writeJSON(w, 400, map[string]any{"error": "targetNodeId and backToMe only apply to return"})
 		return
 	}
 	if req.DataPatch == nil {
@@ -351,7 +537,7 @@ func (s *Server) ActOnTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ok, err := s.userMatchesAssignee(req.UserID, task.AssigneeType, task.AssigneeID)
+	ok, err := userMatchesAssignee(s.DB, userID, task.AssigneeType, task.AssigneeID)
 	if err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
@@ -361,29 +547,59 @@ func (s *Server) ActOnTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if inst.Status != "RUNNING" || inst.CurrentNode != task.NodeID {
-		writeJSON(w, 400, map[string]any{"error": "instance/task node mismatch"})
+	if inst.Status != "RUNNING" {
+		writeJSON(w, 400, map[string]any{"error": "instance not running"})
 		return
 	}
+	if task.Kind != "" && req.Action != "approve" && req.Action != "reject" {
+		writeJSON(w, 400, map[string]any{"error": "add-signers can only approve or reject"})
+		return
+	}
+	switch req.Action {
+	case "transfer":
+		s.transferTask(w, task, inst, schema, userID, req)
+		return
+	case "add_sign_before":
+		s.addSignBefore(w, task, inst, userID, req)
+		return
+	}
+	// add_sign_after is the actor's approval plus the added signers
+	action, signers := req.Action, []string(nil)
+	if action == "add_sign_after" {
+		var msg string
+		if signers, msg = s.checkSigners(task, userID, req.Signers); msg != "" {
+			writeJSON(w, 400, map[string]any{"error": msg})
+			return
+		}
+		action = "approve"
+	}
 
 	if err := enforceEditable(schema, task.NodeID, req.DataPatch); err != nil {
 		writeJSON(w, 400, map[string]any{"error": err.Error()})
 		return
 	}
-	for k, v := range req.DataPatch {
-		inst.Data[k] = v
-	}
-	if err := validateRequired(schema, task.NodeID, inst.Data); err != nil {
+	if err := rejectCalculatedWrites(schema, req.DataPatch); err != nil {
 		writeJSON(w, 400, map[string]any{"error": err.Error()})
 		return
 	}
+	if !s.applyTaskPatch(w, schema, inst, task.NodeID, req.DataPatch) {
+		return
+	}
+	loadedVersion := inst.RowVersion
 
-	edge, found, err := findEdgeByCondition(schema, task.NodeID, req.Action, inst.Data)
-	if err != nil {
+	var edge Edge
+	found := false
+	if action == "return" && req.TargetNodeID != "" {
+		edge, found = Edge{From: task.NodeID, To: req.TargetNodeID, On: "return", Mode: "OR"}, true
+	} else if edge, found, err = s.findEdgeByCondition(s.DB, schema, inst, task.NodeID, action); err != nil {
 		writeJSON(w, 400, map[string]any{"error": err.Error()})
 		return
 	}
-	if !found && req.Action == "reject" {
+	if req.BackToMe && found && schema.Workflow.nodeType(edge.To) != NodeTask {
+		writeJSON(w, 400, map[string]any{"error": "backToMe needs a task node to return to"})
+		return
+	}
+	if !found && action == "reject" {
 		edge = Edge{From: task.NodeID, To: "end", On: "reject", Mode: "OR"}
 		found = true
 	}
@@ -392,92 +608,218 @@ func (s *Server) ActOnTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// load group state
-	var mode, gStatus string
-	var total, approved, rejected int
-	if err := s.DB.QueryRow(`SELECT mode,status,total_count,approved_count,rejected_count FROM task_groups WHERE id=?`, task.GroupID).
-		Scan(&mode, &gStatus, &total, &approved, &rejected); err != nil {
-		writeJSON(w, 500, map[string]any{"error": "task group missing"})
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
-
-	tx, _ := s.DB.Begin()
 	defer tx.Rollback()
 	now := time.Now().UnixMilli()
 
-	// complete current task
-	if _, err := tx.Exec(`UPDATE tasks SET status='DONE', action_taken=?, actor_user_id=?, comment=?, completed_at=? WHERE id=?`,
-		req.Action, req.UserID, req.Comment, now, taskID); err != nil {
+	// the instance may have moved on while this request waited for the
+	// write lock: continue from its current state and data. A data patch
+	// was written against the version the client saw, so it only applies
+	// if nobody changed the instance since; a plain vote always goes through.
+	if err := reloadInstance(tx, inst); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if inst.Status != "RUNNING" {
+		writeJSON(w, 409, map[string]any{"error": errConflict.Error()})
+		return
+	}
+	if !ifMatch(r, inst.RowVersion) || len(req.DataPatch) > 0 && inst.RowVersion != loadedVersion {
+		writeJSON(w, 409, map[string]any{"error": errConflict.Error(), "rowVersion": inst.RowVersion})
+		return
+	}
+	if !s.applyTaskPatch(w, schema, inst, task.NodeID, req.DataPatch) {
+		return
+	}
+
+	eng, err := s.newEngine(tx, inst, schema, now)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+
+	if req.TargetNodeID != "" {
+		msg, err := checkReturnTarget(tx, schema, task, req.TargetNodeID)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		if msg != "" {
+			writeJSON(w, 400, map[string]any{"error": msg})
+			return
+		}
+	}
+
+	// load group state inside the transaction so counters are current
+	var mode, gStatus, tokenID, queueJSON, resumeNode string
+	var total, approved, rejected, approveAt, rejectAt, gVersion int
+	if err := tx.QueryRow(`SELECT mode,status,total_count,approved_count,rejected_count,COALESCE(approve_threshold,0),COALESCE(reject_threshold,0),
+		  row_version,COALESCE(token_id,''),COALESCE(assignees_json,''),COALESCE(resume_node_id,'')
+		FROM task_groups WHERE id=?`, task.GroupID).
+		Scan(&mode, &gStatus, &total, &approved, &rejected, &approveAt, &rejectAt, &gVersion, &tokenID, &queueJSON, &resumeNode); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "task group missing"})
+		return
+	}
+	if gStatus != "OPEN" {
+		writeJSON(w, 409, map[string]any{"error": errConflict.Error()})
+		return
+	}
+	tok, tokStatus, err := eng.loadToken(tokenID)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if tokStatus != "ACTIVE" {
+		writeJSON(w, 409, map[string]any{"error": errConflict.Error()})
+		return
+	}
+
+	// complete current task (it may have been closed since it was read)
+	if err := execVersioned(tx, `UPDATE tasks SET status='DONE', action_taken=?, actor_user_id=?, comment=?, completed_at=? WHERE id=? AND status='PENDING'`,
+		action, userID, req.Comment, now, taskID); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if len(req.DataPatch) > 0 {
+		if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "data_updated", NodeID: task.NodeID, TaskID: taskID, ActorUserID: userID,
+			Payload: map[string]any{"fields": sortedKeys(req.DataPatch)}}, now); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	ev := InstanceEvent{InstanceID: inst.ID, Type: actionEvent[action], NodeID: task.NodeID, TaskID: taskID, ActorUserID: userID, Comment: req.Comment}
+	if action == "return" {
+		ev.Payload = map[string]any{"targetNodeId": edge.To, "backToMe": req.BackToMe}
+	}
+	if err := recordEvent(tx, ev, now); err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
 
-	// update group counters
-	if req.Action == "approve" {
+	if signers != nil {
+		if err := createSignTasks(tx, inst.ID, task, mode, "sign_after", userID, "", signers, now); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+
+	// update group counters; add-signers do not vote in the group
+	if task.Kind == "" && action == "approve" {
 		approved++
-	} else if req.Action == "reject" {
+	} else if task.Kind == "" && action == "reject" {
 		rejected++
 	}
-	if _, err := tx.Exec(`UPDATE task_groups SET approved_count=?, rejected_count=? WHERE id=?`, approved, rejected, task.GroupID); err != nil {
-		writeJSON(w, 500, map[string]any{"error": err.Error()})
+	if err := execVersioned(tx, `UPDATE task_groups SET approved_count=?, rejected_count=?, row_version=row_version+1 WHERE id=? AND row_version=?`,
+		approved, rejected, task.GroupID, gVersion); err != nil {
+		writeTxError(w, err)
 		return
 	}
 
-	closeGroup := func() error {
-		if _, err := tx.Exec(`UPDATE task_groups SET status='CLOSED', closed_at=? WHERE id=?`, now, task.GroupID); err != nil {
-			return err
+	// the group's votes decide the node; return always ends it
+	decision := "return"
+	if action != "return" {
+		if approveAt == 0 || rejectAt == 0 { // groups created before vote rules
+			approveAt, rejectAt = voteThresholds(nil, mode, total)
+		}
+		decision = groupDecision(approved, rejected, total, approveAt, rejectAt)
+	}
+	held, err := holdForSigners(tx, task, action, &decision, now)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	// SEQ: hand over to the next assignee in line
+	if mode == "SEQ" && decision == "" && !held {
+		var queue []Assignee
+		next := approved + rejected
+		if err := json.Unmarshal([]byte(queueJSON), &queue); err != nil || next >= len(queue) {
+			writeJSON(w, 500, map[string]any{"error": "sequential group queue missing"})
+			return
+		}
+		if err := createSeqTask(tx, inst.ID, task.GroupID, task.NodeID, queue, next, now); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		// the rest of the queue may be redundant (autoApprove)
+		passed, err := s.autoApprove(tx, inst, schema, task.GroupID, task.NodeID, now)
+		if err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		if passed {
+			decision = "approve"
 		}
-		_, err := tx.Exec(`UPDATE tasks SET status='DONE', action_taken='auto_closed', completed_at=?
-			WHERE group_id=? AND status='PENDING'`, now, task.GroupID)
-		return err
 	}
 
-	nodeFinished := false
-	if req.Action == "reject" || req.Action == "return" {
-		nodeFinished = true
-	} else {
-		if mode == "" {
-			mode = "OR"
+	// pick the edge again inside the transaction: its condition then sees
+	// this action, and the decisive vote may not be the edge's own action
+	if decision == "approve" || decision == "reject" {
+		if edge, found, err = s.findEdgeByCondition(tx, schema, inst, task.NodeID, decision); err != nil {
+			writeJSON(w, 400, map[string]any{"error": err.Error()})
+			return
 		}
-		if mode == "OR" && req.Action == "approve" {
-			nodeFinished = true
+		if !found && decision == "reject" {
+			edge, found = Edge{From: task.NodeID, To: "end", On: "reject", Mode: "OR"}, true
 		}
-		if mode == "AND" && req.Action == "approve" && approved >= total {
-			nodeFinished = true
+		if !found {
+			writeJSON(w, 400, map[string]any{"error": "no edge for action"})
+			return
 		}
 	}
 
+	// reject stops every branch, return every branch or just its own;
+	// approve only advances the branch (token) this task belongs to
 	nextStatus := inst.Status
-	nextNode := inst.CurrentNode
-
-	if req.Action == "reject" {
-		_ = closeGroup()
+	var moveErr error
+	if decision == "reject" {
+		moveErr = eng.cancelAll()
 		nextStatus = "REJECTED"
-		nextNode = "end"
-	} else if req.Action == "return" {
-		_ = closeGroup()
+	} else if decision == "return" {
+		moveErr = eng.returnFrom(tok, task.NodeID, edge, req.TargetNodeID != "", req.BackToMe)
 		nextStatus = "RUNNING"
-		nextNode = edge.To // usually start
-	} else if nodeFinished {
-		if err := closeGroup(); err != nil {
-			writeJSON(w, 500, map[string]any{"error": err.Error()})
-			return
-		}
-		nextNode = edge.To
-		if nextNode == "end" {
-			nextStatus = "APPROVED"
+	} else if decision == "approve" {
+		if moveErr = eng.closeGroups(tok.ID); moveErr == nil {
+			if resumeNode != "" {
+				moveErr = eng.jumpBack(tok, task.NodeID, resumeNode)
+			} else {
+				moveErr = eng.move(tok, edge)
+			}
 		}
 	}
+	if moveErr != nil {
+		writeJSON(w, 400, map[string]any{"error": moveErr.Error()})
+		return
+	}
+	nextNode, err := eng.currentNode()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if nextStatus == "RUNNING" && nextNode == "end" {
+		nextStatus = "APPROVED"
+	}
+	if nextStatus == "REJECTED" {
+		nextNode = "end"
+	}
 
 	dataJSON, _ := json.Marshal(inst.Data)
-	if _, err := tx.Exec(`UPDATE instances SET status=?, current_node=?, data_json=?, updated_at=? WHERE id=?`,
-		nextStatus, nextNode, string(dataJSON), now, inst.ID); err != nil {
+	if err := execVersioned(tx, `UPDATE instances SET status=?, current_node=?, data_json=?, updated_at=?, row_version=row_version+1
+		WHERE id=? AND row_version=?`,
+		nextStatus, nextNode, string(dataJSON), now, inst.ID, inst.RowVersion); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if err := saveRevision(tx, inst.ID, userID, task.NodeID, inst.Data, now); err != nil {
 		writeJSON(w, 500, map[string]any{"error": err.Error()})
 		return
 	}
-
-	if nodeFinished && nextNode != "end" {
-		if _, err := s.createNodeTasks(tx, inst, nextNode, edge, now); err != nil {
+	if nextNode == "end" {
+		if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "completed", NodeID: "end",
+			Payload: map[string]any{"status": nextStatus}}, now); err != nil {
 			writeJSON(w, 500, map[string]any{"error": err.Error()})
 			return
 		}
@@ -493,10 +835,10 @@ func (s *Server) ActOnTask(w http.ResponseWriter, r *http.Request) {
 /* ---------------- helpers: loading + rules ---------------- */
 
 func (s *Server) loadInstanceWithSchema(instID string) (*Instance, *FormSchema, error) {
-	row := s.DB.QueryRow(`SELECT id,form_id,form_version,status,current_node,data_json,applicant_user_id FROM instances WHERE id=?`, instID)
+	row := s.DB.QueryRow(`SELECT id,form_id,form_version,status,current_node,data_json,applicant_user_id,created_at,submit_count,row_version FROM instances WHERE id=?`, instID)
 	var inst Instance
 	var dataJSON string
-	if err := row.Scan(&inst.ID, &inst.FormID, &inst.FormVersion, &inst.Status, &inst.CurrentNode, &dataJSON, &inst.ApplicantUserID); err != nil {
+	if err := row.Scan(&inst.ID, &inst.FormID, &inst.FormVersion, &inst.Status, &inst.CurrentNode, &dataJSON, &inst.ApplicantUserID, &inst.CreatedAt, &inst.SubmitCount, &inst.RowVersion); err != nil {
 		return nil, nil, errors.New("instance not found")
 	}
 	inst.Data = map[string]any{}
@@ -514,9 +856,10 @@ func (s *Server) loadInstanceWithSchema(instID string) (*Instance, *FormSchema,
 }
 
 func (s *Server) loadTaskInstanceSchema(taskID string) (*Task, *Instance, *FormSchema, error) {
-	row := s.DB.QueryRow(`SELECT id,group_id,instance_id,node_id,status,assignee_type,assignee_id FROM tasks WHERE id=?`, taskID)
+	row := s.DB.QueryRow(`SELECT id,group_id,instance_id,node_id,status,assignee_type,assignee_id,COALESCE(kind,''),COALESCE(parent_task_id,'')
+		FROM tasks WHERE id=?`, taskID)
 	var t Task
-	if err := row.Scan(&t.ID, &t.GroupID, &t.InstanceID, &t.NodeID, &t.Status, &t.AssigneeType, &t.AssigneeID); err != nil {
+	if err := row.Scan(&t.ID, &t.GroupID, &t.InstanceID, &t.NodeID, &t.Status, &t.AssigneeType, &t.AssigneeID, &t.Kind, &t.ParentTaskID); err != nil {
 		return nil, nil, nil, errors.New("task not found")
 	}
 	inst, schema, err := s.loadInstanceWithSchema(t.InstanceID)
@@ -526,37 +869,67 @@ func (s *Server) loadTaskInstanceSchema(taskID string) (*Task, *Instance, *FormS
 	return &t, inst, schema, nil
 }
 
-func findEdgeByCondition(schema *FormSchema, from, on string, data map[string]any) (Edge, bool, error) {
-	for _, e := range schema.Workflow.Edges {
+func withDefaultMode(e Edge) Edge {
+	if e.Mode == "" {
+		e.Mode = "OR"
+	}
+	return e
+}
+
+// findEdgeByCondition picks the edge taken from `from` on action `on`: the
+// first alternative (by priority) whose condition holds, else the default.
+func (s *Server) findEdgeByCondition(q querier, schema *FormSchema, inst *Instance, from, on string) (Edge, bool, error) {
+	ctx, err := conditionContext(q, inst, from)
+	if err != nil {
+		return Edge{}, false, err
+	}
+	var idx []int
+	def := -1
+	for i, e := range schema.Workflow.Edges {
 		if e.From != from || e.On != on {
 			continue
 		}
-		if e.Mode == "" {
-			e.Mode = "OR"
+		if e.IsDefault {
+			if def < 0 {
+				def = i
+			}
+			continue
 		}
-		ok, err := EvalJsonLogic(e.Condition, JLContext{Form: data})
+		idx = append(idx, i)
+	}
+	for _, i := range orderedAlternatives(schema.Workflow.Edges, idx) {
+		e := schema.Workflow.Edges[i]
+		ok, err := EvalJsonLogic(e.Condition, ctx)
 		if err != nil {
 			return Edge{}, false, err
 		}
 		if ok {
-			return e, true, nil
+			return withDefaultMode(e), true, nil
 		}
 	}
+	if def >= 0 {
+		return withDefaultMode(schema.Workflow.Edges[def]), true, nil
+	}
 	return Edge{}, false, nil
 }
 
-func validateRequired(schema *FormSchema, nodeID string, data map[string]any) error {
+// validateRequired checks the node's required fields; hidden fields are
+// never required.
+func validateRequired(schema *FormSchema, nodeID string, data map[string]any, visible map[string]bool) []FieldError {
 	p, ok := schema.Workflow.Policies[nodeID]
 	if !ok {
 		return nil
 	}
+	var errs []FieldError
 	for _, fid := range p.Required {
-		v, exists := data[fid]
-		if !exists || v == nil || isEmptyString(v) {
-			return errors.New("missing required field: " + fid)
+		if !visible[fid] {
+			continue
+		}
+		if isEmptyValue(data[fid]) {
+			errs = append(errs, FieldError{FieldID: fid, Message: "required"})
 		}
 	}
-	return nil
+	return errs
 }
 
 func enforceEditable(schema *FormSchema, nodeID string, patch map[string]any) error {
@@ -586,19 +959,21 @@ func enforceEditable(schema *FormSchema, nodeID string, patch map[string]any) er
 	return nil
 }
 
-func (s *Server) userMatchesAssignee(userID, typ, id string) (bool, error) {
+// userMatchesAssignee reports whether userID is (a member of) the assignee;
+// inside a transaction q must be the transaction.
+func userMatchesAssignee(q querier, userID, typ, id string) (bool, error) {
 	switch typ {
 	case "user":
 		return userID == id, nil
 	case "role":
 		var cnt int
-		if err := s.DB.QueryRow(`SELECT COUNT(1) FROM user_roles WHERE user_id=? AND role_id=?`, userID, id).Scan(&cnt); err != nil {
+		if err := q.QueryRow(`SELECT COUNT(1) FROM user_roles WHERE user_id=? AND role_id=?`, userID, id).Scan(&cnt); err != nil {
 			return false, err
 		}
 		return cnt > 0, nil
 	case "dept":
 		var cnt int
-		if err := s.DB.QueryRow(`SELECT COUNT(1) FROM user_depts WHERE user_id=? AND dept_id=?`, userID, id).Scan(&cnt); err != nil {
+		if err := q.QueryRow(`SELECT COUNT(1) FROM user_depts WHERE user_id=? AND dept_id=?`, userID, id).Scan(&cnt); err != nil {
 			return false, err
 		}
 		return cnt > 0, nil
@@ -612,40 +987,132 @@ func isEmptyString(v any) bool {
 	return ok && s == ""
 }
 
-func (s *Server) createNodeTasks(tx *sql.Tx, inst *Instance, nodeID string, edge Edge, now int64) (string, error) {
+// createNodeTasks opens the node's task group. It reports whether the node
+// already passed: skipped by onEmptyAssignee or by the autoApprove rule.
+func (s *Server) createNodeTasks(tx *sql.Tx, inst *Instance, schema *FormSchema, nodeID string, edge Edge, tokenID string, now int64) (bool, error) {
 	if nodeID == "end" {
-		return "", nil
-	}
-	assignees := edge.Assignees
-	if len(assignees) == 0 {
-		return "", errors.New("node " + nodeID + " has no assignees")
+		return false, nil
 	}
 	mode := edge.Mode
 	if mode == "" {
 		mode = "OR"
 	}
 
+	var resolved []Assignee
+	var err error
+	if len(edge.Assignees) == 0 {
+		err = &emptyAssigneeError{"node " + nodeID + " has no assignees"}
+	} else {
+		resolved, err = s.resolveAssignees(tx, inst, schema, edge.Assignees)
+	}
+	if err != nil {
+		var pass bool
+		if resolved, pass, err = onEmptyAssignee(tx, inst, schema, nodeID, resolved, err, now); err != nil || pass {
+			return pass, err
+		}
+	}
+
+	approveAt, rejectAt := voteThresholds(edge.Vote, mode, len(resolved))
+	groupID, err := openGroup(tx, inst.ID, nodeID, mode, resolved, approveAt, rejectAt, tokenID, now)
+	if err != nil {
+		return false, err
+	}
+	return s.autoApprove(tx, inst, schema, groupID, nodeID, now)
+}
+
+// openGroup creates a task group and its tasks. The resolved assignees are
+// kept on the group: SEQ groups open one task at a time from that list, and
+// returning to the node later recreates the same tasks.
+func openGroup(tx *sql.Tx, instID, nodeID, mode string, resolved []Assignee, approveAt, rejectAt int, tokenID string, now int64) (string, error) {
+	b, _ := json.Marshal(resolved)
 	groupID := newID("tg")
-	total := len(assignees)
-	if _, err := tx.Exec(`INSERT INTO task_groups(id,instance_id,node_id,mode,status,total_count,approved_count,rejected_count,created_at)
-		VALUES (?,?,?,?,?,?,?,?,?)`,
-		groupID, inst.ID, nodeID, mode, "OPEN", total, 0, 0, now); err != nil {
+	if _, err := tx.Exec(`INSERT INTO task_groups(id,instance_id,node_id,mode,status,total_count,approved_count,rejected_count,approve_threshold,reject_threshold,token_id,assignees_json,created_at)
+		VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		groupID, instID, nodeID, mode, "OPEN", len(resolved), 0, 0, approveAt, rejectAt, tokenID, string(b), now); err != nil {
 		return "", err
 	}
 
-	for _, a := range assignees {
-		typ := a.Type
-		aid := a.ID
-		if typ == "applicant" {
-			typ = "user"
-			aid = inst.ApplicantUserID
-		}
-		taskID := newID("task")
-		if _, err := tx.Exec(`INSERT INTO tasks(id,group_id,instance_id,node_id,status,assignee_type,assignee_id,created_at)
-			VALUES (?,?,?,?,?,?,?,?)`,
-			taskID, groupID, inst.ID, nodeID, "PENDING", typ, aid, now); err != nil {
+	if mode == "SEQ" {
+		return groupID, createSeqTask(tx, instID, groupID, nodeID, resolved, 0, now)
+	}
+	for _, a := range resolved {
+		if _, err := createTask(tx, instID, groupID, nodeID, mode, a, nil, now); err != nil {
 			return "", err
 		}
 	}
 	return groupID, nil
 }
+
+func createTask(tx *sql.Tx, instID, groupID, nodeID, mode string, a Assignee, extra map[string]any, now int64) (string, error) {
+	taskID := newID("task")
+	if _, err := tx.Exec(`INSERT INTO tasks(id,group_id,instance_id,node_id,status,assignee_type,assignee_id,created_at)
+		VALUES (?,?,?,?,?,?,?,?)`,
+		taskID, groupID, instID, nodeID, "PENDING", a.Type, a.ID, now); err != nil {
+		return "", err
+	}
+	payload := map[string]any{"groupId": groupID, "mode": mode, "assigneeType": a.Type, "assigneeId": a.ID}
+	for k, v := range extra {
+		payload[k] = v
+	}
+	return taskID, recordEvent(tx, InstanceEvent{InstanceID: instID, Type: "task_created", NodeID: nodeID, TaskID: taskID, Payload: payload}, now)
+}
+
+// createSeqTask opens the task of the i-th assignee of a SEQ group; the
+// event lists who is still queued after it.
+func createSeqTask(tx *sql.Tx, instID, groupID, nodeID string, queue []Assignee, i int, now int64) error {
+	_, err := createTask(tx, instID, groupID, nodeID, "SEQ", queue[i], map[string]any{
+		"position": i + 1,
+		"queue":    queue[i+1:],
+	}, now)
+	return err
+}
+
+// closePendingTasks auto-closes the still pending (or suspended) tasks of a
+// group; action is what they are closed as ("" for auto_closed).
+func closePendingTasks(tx *sql.Tx, instID, groupID, action string, now int64) error {
+	return closeTasksWhere(tx, instID, action, now, `group_id=?`, groupID)
+}
+
+// closeTasksWhere is closePendingTasks for the open tasks matching cond.
+func closeTasksWhere(tx *sql.Tx, instID, action string, now int64, cond string, args ...any) error {
+	if action == "" {
+		action = "auto_closed"
+	}
+	rows, err := tx.Query(`SELECT id, node_id FROM tasks WHERE `+cond+` AND status IN ('PENDING','WAITING')`, args...)
+	if err != nil {
+		return err
+	}
+	var evs []InstanceEvent
+	for rows.Next() {
+		ev := InstanceEvent{InstanceID: instID, Type: "auto_closed"}
+		if action != "auto_closed" {
+			ev.Payload = map[string]any{"action": action}
+		}
+		if err := rows.Scan(&ev.TaskID, &ev.NodeID); err != nil {
+			rows.Close()
+			return err
+		}
+		evs = append(evs, ev)
+	}
+	rows.Close()
+
+	if _, err := tx.Exec(`UPDATE tasks SET status='DONE', action_taken=?, completed_at=?
+		WHERE `+cond+` AND status IN ('PENDING','WAITING')`, append([]any{action, now}, args...)...); err != nil {
+		return err
+	}
+	for _, ev := range evs {
+		if err := recordEvent(tx, ev, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
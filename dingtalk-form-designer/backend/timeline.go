@@ -0,0 +1,166 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// InstanceEvent is one entry of an instance's append-only history.
+type InstanceEvent struct {
+	InstanceID  string
+	Type        string // created|data_updated|submitted|task_created|approved|rejected|returned|transferred|add_signed|resumed|jumped_back|withdrawn|auto_approved|auto_closed|no_assignee|forked|joined|completed
+	NodeID      string
+	TaskID      string
+	ActorUserID string
+	Comment     string
+	Payload     map[string]any
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// querier is satisfied by both *sql.DB and *sql.Tx, so reads made inside a
+// transaction see its own writes.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func recordEvent(x execer, ev InstanceEvent, now int64) error {
+	var payload any
+	if ev.Payload != nil {
+		b, _ := json.Marshal(ev.Payload)
+		payload = string(b)
+	}
+	_, err := x.Exec(`INSERT INTO instance_events(instance_id,type,node_id,task_id,actor_user_id,comment,payload_json,created_at)
+		VALUES (?,?,?,?,?,?,?,?)`,
+		ev.InstanceID, ev.Type, nullIfEmpty(ev.NodeID), nullIfEmpty(ev.TaskID), nullIfEmpty(ev.ActorUserID), nullIfEmpty(ev.Comment), payload, now)
+	return err
+}
+
+// actionEvent maps a task action to its timeline event type.
+var actionEvent = map[string]string{
+	"approve": "approved",
+	"reject":  "rejected",
+	"return":  "returned",
+}
+
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+type TimelineEvent struct {
+	ID          int64          `json:"id"`
+	Type        string         `json:"type"`
+	NodeID      string         `json:"nodeId,omitempty"`
+	NodeName    string         `json:"nodeName,omitempty"`
+	TaskID      string         `json:"taskId,omitempty"`
+	ActorUserID string         `json:"actorUserId,omitempty"`
+	ActorName   string         `json:"actorName,omitempty"`
+	Comment     string         `json:"comment,omitempty"`
+	Payload     map[string]any `json:"payload,omitempty"`
+	CreatedAt   int64          `json:"createdAt"`
+}
+
+func (s *Server) GetTimeline(w http.ResponseWriter, r *http.Request) {
+	instID := chi.URLParam(r, "id")
+	inst, schema, err := s.loadInstanceWithSchema(instID)
+	if err != nil {
+		writeJSON(w, 404, map[string]any{"error": err.Error()})
+		return
+	}
+	if viewerID := currentUserID(r); viewerID != inst.ApplicantUserID {
+		if _, err := s.viewerNodes(inst, viewerID); err != nil {
+			writeViewError(w, err)
+			return
+		}
+	}
+	nodeNames := map[string]string{}
+	for _, n := range schema.Workflow.Nodes {
+		nodeNames[n.ID] = n.Name
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT e.id, e.type, COALESCE(e.node_id,''), COALESCE(e.task_id,''), COALESCE(e.actor_user_id,''),
+		  COALESCE(u.name,''), COALESCE(e.comment,''), COALESCE(e.payload_json,''), e.created_at
+		FROM instance_events e
+		LEFT JOIN users u ON u.id = e.actor_user_id
+		WHERE e.instance_id=?
+		ORDER BY e.id`, instID)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []TimelineEvent{}
+	for rows.Next() {
+		var x TimelineEvent
+		var payload string
+		if err := rows.Scan(&x.ID, &x.Type, &x.NodeID, &x.TaskID, &x.ActorUserID, &x.ActorName, &x.Comment, &payload, &x.CreatedAt); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		x.NodeName = nodeNames[x.NodeID]
+		if payload != "" {
+			_ = json.Unmarshal([]byte(payload), &x.Payload)
+		}
+		out = append(out, x)
+	}
+	if err := rows.Err(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+
+	// resolve assignee display names for task_created events
+	for i := range out {
+		if out[i].Type == "transferred" && out[i].Payload != nil {
+			id, _ := out[i].Payload["toUserId"].(string)
+			out[i].Payload["toName"] = s.assigneeName("user", id)
+			continue
+		}
+		if out[i].Type != "task_created" || out[i].Payload == nil {
+			continue
+		}
+		typ, _ := out[i].Payload["assigneeType"].(string)
+		id, _ := out[i].Payload["assigneeId"].(string)
+		if name := s.assigneeName(typ, id); name != "" {
+			out[i].Payload["assigneeName"] = name
+		}
+		// SEQ: assignees still waiting after this one
+		queue, _ := out[i].Payload["queue"].([]any)
+		for _, q := range queue {
+			if a, ok := q.(map[string]any); ok {
+				typ, _ := a["type"].(string)
+				id, _ := a["id"].(string)
+				a["name"] = s.assigneeName(typ, id)
+			}
+		}
+	}
+	writeJSON(w, 200, out)
+}
+
+func (s *Server) assigneeName(typ, id string) string {
+	var name string
+	switch typ {
+	case "user":
+		_ = s.DB.QueryRow(`SELECT name FROM users WHERE id=?`, id).Scan(&name)
+	case "dept":
+		_ = s.DB.QueryRow(`SELECT name FROM depts WHERE id=?`, id).Scan(&name)
+	case "role":
+		if err := s.DB.QueryRow(`SELECT name FROM roles WHERE id=?`, id).Scan(&name); err != nil {
+			name = id
+		}
+	default:
+		name = id
+	}
+	return name
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// transferTask hands a pending task to another user (转交). The old task is
+// closed as "transferred" and a new one for the target opens in the same
+// group; vote counters are left alone, so the node still needs the same
+// votes. Nodes can opt out with disallowTransfer.
+func (s *Server) transferTask(w http.ResponseWriter, task *Task, inst *Instance, schema *FormSchema, userID string, req ActReq) {
+	if n, _ := schema.Workflow.node(task.NodeID); n.DisallowTransfer {
+		writeJSON(w, 400, map[string]any{"error": "transfer not allowed at node " + task.NodeID})
+		return
+	}
+	if len(req.DataPatch) > 0 {
+		writeJSON(w, 400, map[string]any{"error": "dataPatch not allowed with transfer"})
+		return
+	}
+	to := req.TransferTo
+	if to == "" {
+		writeJSON(w, 400, map[string]any{"error": "transferTo required"})
+		return
+	}
+	if to == userID || (task.AssigneeType == "user" && task.AssigneeID == to) {
+		writeJSON(w, 400, map[string]any{"error": "task is already assigned to " + to})
+		return
+	}
+	var active bool
+	if err := s.DB.QueryRow(`SELECT active FROM users WHERE id=?`, to).Scan(&active); err != nil || !active {
+		writeJSON(w, 400, map[string]any{"error": "unknown or inactive user: " + to})
+		return
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().UnixMilli()
+
+	var mode, gStatus string
+	var gVersion int
+	if err := tx.QueryRow(`SELECT mode,status,row_version FROM task_groups WHERE id=?`, task.GroupID).Scan(&mode, &gStatus, &gVersion); err != nil {
+		writeJSON(w, 500, map[string]any{"error": "task group missing"})
+		return
+	}
+	if gStatus != "OPEN" {
+		writeJSON(w, 409, map[string]any{"error": errConflict.Error()})
+		return
+	}
+	var dup int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM tasks WHERE group_id=? AND status='PENDING' AND assignee_type='user' AND assignee_id=?`,
+		task.GroupID, to).Scan(&dup); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if dup > 0 {
+		writeJSON(w, 400, map[string]any{"error": to + " already has a pending task at this node"})
+		return
+	}
+
+	if err := execVersioned(tx, `UPDATE tasks SET status='DONE', action_taken='transferred', actor_user_id=?, comment=?, completed_at=? WHERE id=? AND status='PENDING'`,
+		userID, req.Comment, now, task.ID); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	// counters stay the same; the version bump makes concurrent votes retry
+	if err := execVersioned(tx, `UPDATE task_groups SET row_version=row_version+1 WHERE id=? AND row_version=?`, task.GroupID, gVersion); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "transferred", NodeID: task.NodeID, TaskID: task.ID, ActorUserID: userID,
+		Comment: req.Comment, Payload: map[string]any{"toUserId": to}}, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	newTaskID, err := createTask(tx, inst.ID, task.GroupID, task.NodeID, mode, Assignee{Type: "user", ID: to},
+		map[string]any{"transferredFrom": task.ID}, now)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true, "instanceId": inst.ID, "instanceStatus": inst.Status, "currentNode": inst.CurrentNode, "taskId": newTaskID})
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// visibleFields computes effective visibility at nodeID: the node policy's
+// visible list (all fields when the node has no policy) intersected with
+// each field's visibleWhen rule. A rule that fails to evaluate hides the
+// field, as the frontend renderer does.
+func visibleFields(schema *FormSchema, nodeID string, ctx JLContext) map[string]bool {
+	p, hasPolicy := schema.Workflow.Policies[nodeID]
+	all := !hasPolicy || p.Visible == nil
+	allowed := map[string]bool{}
+	if !all {
+		for _, fid := range p.Visible {
+			if fid == "*" {
+				all = true
+			}
+			allowed[fid] = true
+		}
+	}
+
+	visible := map[string]bool{}
+	for _, f := range schema.Fields {
+		if !all && !allowed[f.ID] {
+			continue
+		}
+		if f.VisibleWhen != nil {
+			ok, err := EvalJsonLogic(f.VisibleWhen, ctx)
+			if err != nil || !ok {
+				continue
+			}
+		}
+		visible[f.ID] = true
+	}
+	return visible
+}
+
+// visibilityAt evaluates visibleFields for an instance with the same context
+// edge conditions get (form data, applicant, instance, node).
+func (s *Server) visibilityAt(schema *FormSchema, inst *Instance, nodeID string) (map[string]bool, error) {
+	ctx, err := conditionContext(s.DB, inst, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return visibleFields(schema, nodeID, ctx), nil
+}
+
+var errNotParticipant = errors.New("not a participant of this instance")
+
+// viewerNodes lists the nodes viewerID has or had tasks at in inst: as the
+// assignee (directly or through a role or dept) or as the one who acted.
+// Nobody else besides the applicant may look at the instance, so no nodes
+// is errNotParticipant.
+func (s *Server) viewerNodes(inst *Instance, viewerID string) ([]string, error) {
+	nodes, err := queryStrings(s.DB, `SELECT DISTINCT node_id FROM tasks WHERE instance_id=? AND (
+		  actor_user_id=?
+		  OR (assignee_type='user' AND assignee_id=?)
+		  OR (assignee_type='role' AND assignee_id IN (SELECT role_id FROM user_roles WHERE user_id=?))
+		  OR (assignee_type='dept' AND assignee_id IN (SELECT dept_id FROM user_depts WHERE user_id=?)))
+		ORDER BY node_id`, inst.ID, viewerID, viewerID, viewerID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, errNotParticipant
+	}
+	return nodes, nil
+}
+
+// visibilityUnion is the union of visibilityAt over nodes.
+func (s *Server) visibilityUnion(schema *FormSchema, inst *Instance, nodes []string) (map[string]bool, error) {
+	visible := map[string]bool{}
+	for _, nodeID := range nodes {
+		v, err := s.visibilityAt(schema, inst, nodeID)
+		if err != nil {
+			return nil, err
+		}
+		for k := range v {
+			visible[k] = true
+		}
+	}
+	return visible, nil
+}
+
+// writeViewError reports errNotParticipant as 403 and anything else as 500.
+func writeViewError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNotParticipant) {
+		writeJSON(w, 403, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 500, map[string]any{"error": err.Error()})
+}
+
+// enforceVisible rejects patches that write fields hidden at nodeID.
+func enforceVisible(visible map[string]bool, nodeID string, patch map[string]any) error {
+	for k := range patch {
+		if !visible[k] {
+			return errors.New("field not visible at node " + nodeID + ": " + k)
+		}
+	}
+	return nil
+}
+
+// stripHidden returns a copy of data without the values of hidden fields.
+func stripHidden(data map[string]any, visible map[string]bool) map[string]any {
+	out := map[string]any{}
+	for k, v := range data {
+		if visible[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// schemaForViewer returns a copy of schema with hidden fields removed.
+func schemaForViewer(schema *FormSchema, visible map[string]bool) FormSchema {
+	out := *schema
+	out.Fields = nil
+	for _, f := range schema.Fields {
+		if visible[f.ID] {
+			out.Fields = append(out.Fields, f)
+		}
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package main
+
+import "math"
+
+// VoteRule decides a countersign group by counting votes instead of waiting
+// for everyone: the node passes once Approve is reached and fails once Reject
+// is reached (or once Approve can no longer be reached).
+type VoteRule struct {
+	Approve Threshold `json:"approve"`
+	Reject  Threshold `json:"reject"`
+}
+
+// Threshold is an absolute count or a percentage of the group's assignees
+// (rounded up). An empty threshold falls back to the mode's default.
+type Threshold struct {
+	Count   int     `json:"count,omitempty"`
+	Percent float64 `json:"percent,omitempty"`
+}
+
+func (t Threshold) resolve(total, def int) int {
+	n := def
+	switch {
+	case t.Count > 0:
+		n = t.Count
+	case t.Percent > 0:
+		n = int(math.Ceil(float64(total) * t.Percent / 100))
+	}
+	return min(max(n, 1), total)
+}
+
+// voteThresholds resolves the votes a group of total assignees needs.
+// Without a rule OR passes on the first approval and AND/SEQ need everyone;
+// a single reject fails the node.
+func voteThresholds(v *VoteRule, mode string, total int) (approveAt, rejectAt int) {
+	approveAt, rejectAt = total, 1
+	if mode == "OR" || mode == "" {
+		approveAt = 1
+	}
+	if v == nil {
+		return approveAt, rejectAt
+	}
+	return v.Approve.resolve(total, approveAt), v.Reject.resolve(total, rejectAt)
+}
+
+// groupDecision returns "approve", "reject" or "" while undecided.
+func groupDecision(approved, rejected, total, approveAt, rejectAt int) string {
+	switch {
+	case rejected >= rejectAt:
+		return "reject"
+	case approved >= approveAt:
+		return "approve"
+	case total-rejected < approveAt:
+		return "reject" // too many rejections left for approval
+	}
+	return ""
+}
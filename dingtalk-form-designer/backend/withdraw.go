@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// WithdrawInstance lets the applicant pull back (撤回) a running instance
+// when the form's withdraw rule allows it. Open tasks are closed as
+// "withdrawn"; the instance goes back to start as a DRAFT, or ends as
+// WITHDRAWN when the rule says so.
+func (s *Server) WithdrawInstance(w http.ResponseWriter, r *http.Request) {
+	instID := chi.URLParam(r, "id")
+	userID := currentUserID(r)
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req) // the body is optional
+
+	inst, schema, err := s.loadInstanceWithSchema(instID)
+	if err != nil {
+		writeJSON(w, 404, map[string]any{"error": err.Error()})
+		return
+	}
+	if inst.ApplicantUserID != userID {
+		writeJSON(w, 403, map[string]any{"error": "only applicant can withdraw"})
+		return
+	}
+	if inst.Status != "RUNNING" {
+		writeJSON(w, 400, map[string]any{"error": "instance not running"})
+		return
+	}
+	rule := WithdrawRule{}
+	if schema.Workflow.Withdraw != nil {
+		rule = *schema.Workflow.Withdraw
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+	now := time.Now().UnixMilli()
+
+	switch rule.When {
+	case "never":
+		writeJSON(w, 400, map[string]any{"error": "this form does not allow withdrawing"})
+		return
+	case "", "before_approval":
+		// back at start after a return nothing is with the approvers
+		if inst.CurrentNode == "start" {
+			break
+		}
+		var acted int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM tasks
+			WHERE instance_id=? AND node_id<>'start' AND action_taken IN ('approve','reject')
+			  AND completed_at >= COALESCE((SELECT MAX(created_at) FROM instance_events WHERE instance_id=? AND type='submitted'),0)`,
+			inst.ID, inst.ID).Scan(&acted); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+		if acted > 0 {
+			writeJSON(w, 400, map[string]any{"error": "already being approved, withdraw is no longer allowed"})
+			return
+		}
+	}
+
+	eng, err := s.newEngine(tx, inst, schema, now)
+	if err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	eng.closeAs = "withdrawn"
+	if err := eng.cancelAll(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+
+	status, node := "WITHDRAWN", "end"
+	if rule.To == "" || rule.To == "draft" {
+		status, node = "DRAFT", "start"
+		if _, err := eng.newToken("start", ""); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+	if err := execVersioned(tx, `UPDATE instances SET status=?, current_node=?, updated_at=?, row_version=row_version+1 WHERE id=? AND row_version=?`,
+		status, node, now, inst.ID, inst.RowVersion); err != nil {
+		writeTxError(w, err)
+		return
+	}
+	if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "withdrawn", NodeID: inst.CurrentNode, ActorUserID: userID, Comment: req.Comment,
+		Payload: map[string]any{"status": status}}, now); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	if status == "WITHDRAWN" {
+		if err := recordEvent(tx, InstanceEvent{InstanceID: inst.ID, Type: "completed", NodeID: "end",
+			Payload: map[string]any{"status": status}}, now); err != nil {
+			writeJSON(w, 500, map[string]any{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		writeJSON(w, 500, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, 200, map[string]any{"ok": true, "instanceId": inst.ID, "instanceStatus": status, "currentNode": node})
+}
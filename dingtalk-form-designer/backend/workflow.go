@@ -0,0 +1,382 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Node types. A node without a type is a task node, except "end".
+const (
+	NodeTask         = "task"
+	NodeParallelFork = "parallel_fork"
+	NodeParallelJoin = "parallel_join"
+	NodeExclusive    = "exclusive"
+	NodeEnd          = "end"
+)
+
+func (wf *Workflow) node(id string) (Node, bool) {
+	for _, n := range wf.Nodes {
+		if n.ID == id {
+			return n, true
+		}
+	}
+	return Node{}, false
+}
+
+func (wf *Workflow) nodeType(id string) string {
+	n, _ := wf.node(id)
+	switch {
+	case n.Type != "":
+		return n.Type
+	case id == "end":
+		return NodeEnd
+	default:
+		return NodeTask
+	}
+}
+
+// leadsTo reports whether from reaches to along the edges that move an
+// instance forward (all but return edges).
+func (wf *Workflow) leadsTo(from, to string) bool {
+	seen := map[string]bool{from: true}
+	queue := []string{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, e := range wf.Edges {
+			if e.From != cur || e.On == "return" || seen[e.To] {
+				continue
+			}
+			if e.To == to {
+				return true
+			}
+			seen[e.To] = true
+			queue = append(queue, e.To)
+		}
+	}
+	return false
+}
+
+func isGateway(typ string) bool {
+	return typ == NodeParallelFork || typ == NodeParallelJoin || typ == NodeExclusive
+}
+
+// ActiveNodes lists the nodes holding an active token; current_node stores
+// them comma separated.
+func (inst *Instance) ActiveNodes() []string {
+	return strings.Split(inst.CurrentNode, ",")
+}
+
+/*
+Tokens: an instance holds one ACTIVE token per branch that is currently
+being worked on. A parallel_fork finishes the incoming token and starts one
+child token per outgoing edge (parent_token_id = the incoming token). Child
+tokens reaching a parallel_join wait there; once the join is satisfied
+(joinMode all: no sibling is still active, any: the first arrival) the
+siblings are finished and the parent token carries on from the join, so
+forks can nest and a token's ancestors are the forks enclosing it.
+
+Token status: ACTIVE|WAITING|DONE|CANCELLED.
+*/
+
+// engine moves an instance's tokens inside one transaction.
+type engine struct {
+	s      *Server
+	tx     *sql.Tx
+	inst   *Instance
+	schema *FormSchema
+	now    int64
+
+	closeAs string // action_taken for tasks closed by the engine (default auto_closed)
+}
+
+type token struct {
+	ID       string
+	ParentID string
+}
+
+// maxGatewayHops bounds gateway-only (or auto-approved) paths so a cycle fails
+// instead of recursing forever.
+const maxGatewayHops = 100
+
+func (s *Server) newEngine(tx *sql.Tx, inst *Instance, schema *FormSchema, now int64) (*engine, error) {
+	e := &engine{s: s, tx: tx, inst: inst, schema: schema, now: now}
+	return e, e.ensureTokens()
+}
+
+// ensureTokens gives instances created before tokens existed a single token
+// at their current node, owning the node's open task groups.
+func (e *engine) ensureTokens() error {
+	var cnt int
+	if err := e.tx.QueryRow(`SELECT COUNT(1) FROM instance_tokens WHERE instance_id=?`, e.inst.ID).Scan(&cnt); err != nil {
+		return err
+	}
+	if cnt > 0 || e.inst.CurrentNode == "end" {
+		return nil
+	}
+	tok, err := e.newToken(e.inst.CurrentNode, "")
+	if err != nil {
+		return err
+	}
+	_, err = e.tx.Exec(`UPDATE task_groups SET token_id=? WHERE instance_id=? AND status='OPEN' AND token_id IS NULL`, tok.ID, e.inst.ID)
+	return err
+}
+
+func (e *engine) newToken(nodeID, parentID string) (token, error) {
+	tok := token{ID: newID("tok"), ParentID: parentID}
+	_, err := e.tx.Exec(`INSERT INTO instance_tokens(id,instance_id,node_id,status,parent_token_id,created_at) VALUES (?,?,?,?,?,?)`,
+		tok.ID, e.inst.ID, nodeID, "ACTIVE", nullIfEmpty(parentID), e.now)
+	return tok, err
+}
+
+func (e *engine) loadToken(id string) (token, string, error) {
+	tok := token{ID: id}
+	var status string
+	err := e.tx.QueryRow(`SELECT COALESCE(parent_token_id,''), status FROM instance_tokens WHERE id=? AND instance_id=?`, id, e.inst.ID).
+		Scan(&tok.ParentID, &status)
+	if err != nil {
+		return tok, "", errors.New("token not found: " + id)
+	}
+	return tok, status, nil
+}
+
+// activeTokenAt returns the active token at nodeID, if any.
+func (e *engine) activeTokenAt(nodeID string) (token, bool, error) {
+	var tok token
+	err := e.tx.QueryRow(`SELECT id, COALESCE(parent_token_id,'') FROM instance_tokens
+		WHERE instance_id=? AND node_id=? AND status='ACTIVE' ORDER BY created_at LIMIT 1`, e.inst.ID, nodeID).Scan(&tok.ID, &tok.ParentID)
+	if err == sql.ErrNoRows {
+		return tok, false, nil
+	}
+	return tok, err == nil, err
+}
+
+func (e *engine) setToken(id, nodeID, status string) error {
+	closedAt := any(nil)
+	if status == "DONE" || status == "CANCELLED" {
+		closedAt = e.now
+	}
+	_, err := e.tx.Exec(`UPDATE instance_tokens SET node_id=?, status=?, closed_at=? WHERE id=?`, nodeID, status, closedAt, id)
+	return err
+}
+
+// move sends tok along edge into edge.To, passing through gateways until
+// every resulting token rests on a task node, waits at a join or has ended.
+func (e *engine) move(tok token, edge Edge) error {
+	return e.moveHops(tok, edge, 0)
+}
+
+func (e *engine) moveHops(tok token, edge Edge, hops int) error {
+	if hops > maxGatewayHops {
+		return errors.New("gateway loop at " + edge.To)
+	}
+	to := edge.To
+	wf := &e.schema.Workflow
+	switch wf.nodeType(to) {
+	case NodeEnd:
+		return e.setToken(tok.ID, to, "DONE")
+
+	case NodeTask:
+		if err := e.setToken(tok.ID, to, "ACTIVE"); err != nil {
+			return err
+		}
+		passed, err := e.s.createNodeTasks(e.tx, e.inst, e.schema, to, edge, tok.ID, e.now)
+		if err != nil || !passed {
+			return err
+		}
+		// every vote needed was auto-approved: carry on as if approved
+		if err := e.closeGroups(tok.ID); err != nil {
+			return err
+		}
+		next, found, err := e.s.findEdgeByCondition(e.tx, e.schema, e.inst, to, "approve")
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.New("no approve edge from " + to)
+		}
+		return e.moveHops(tok, next, hops+1)
+
+	case NodeExclusive:
+		if err := e.setToken(tok.ID, to, "ACTIVE"); err != nil {
+			return err
+		}
+		next, found, err := e.s.findEdgeByCondition(e.tx, e.schema, e.inst, to, "")
+		if err != nil {
+			return err
+		}
+		if !found {
+			return errors.New("no branch matched at gateway " + to)
+		}
+		return e.moveHops(tok, next, hops+1)
+
+	case NodeParallelFork:
+		if err := e.setToken(tok.ID, to, "DONE"); err != nil {
+			return err
+		}
+		var branches []Edge
+		for _, out := range wf.Edges {
+			if out.From == to {
+				branches = append(branches, out)
+			}
+		}
+		targets := make([]string, 0, len(branches))
+		for _, b := range branches {
+			targets = append(targets, b.To)
+		}
+		if err := recordEvent(e.tx, InstanceEvent{InstanceID: e.inst.ID, Type: "forked", NodeID: to,
+			Payload: map[string]any{"branches": targets}}, e.now); err != nil {
+			return err
+		}
+		// create every child first so a join reached by the first branch
+		// still sees its siblings as active
+		children := make([]token, len(branches))
+		for i := range branches {
+			child, err := e.newToken(to, tok.ID)
+			if err != nil {
+				return err
+			}
+			children[i] = child
+		}
+		for i, b := range branches {
+			// a join-any fired by an earlier branch cancels the rest
+			_, status, err := e.loadToken(children[i].ID)
+			if err != nil {
+				return err
+			}
+			if status != "ACTIVE" {
+				continue
+			}
+			if err := e.moveHops(children[i], b, hops+1); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case NodeParallelJoin:
+		if err := e.setToken(tok.ID, to, "WAITING"); err != nil {
+			return err
+		}
+		return e.join(tok, to, hops)
+	}
+	return errors.New("unknown node type at " + to)
+}
+
+// join fires joinID once tok's siblings satisfy the join mode.
+func (e *engine) join(tok token, joinID string, hops int) error {
+	n, _ := e.schema.Workflow.node(joinID)
+	var cont token
+	if tok.ParentID != "" {
+		if n.JoinMode != "any" {
+			var active int
+			if err := e.tx.QueryRow(`SELECT COUNT(1) FROM instance_tokens WHERE parent_token_id=? AND status='ACTIVE'`, tok.ParentID).
+				Scan(&active); err != nil {
+				return err
+			}
+			if active > 0 {
+				return nil
+			}
+		}
+		parent, _, err := e.loadToken(tok.ParentID)
+		if err != nil {
+			return err
+		}
+		cont = parent
+
+		// the siblings that did not make it are cancelled (join any)
+		ids, err := queryStrings(e.tx, `SELECT id FROM instance_tokens WHERE parent_token_id=? AND status='ACTIVE'`, tok.ParentID)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := e.cancelToken(id); err != nil {
+				return err
+			}
+		}
+		if _, err := e.tx.Exec(`UPDATE instance_tokens SET status='DONE', closed_at=? WHERE parent_token_id=? AND status='WAITING'`,
+			e.now, tok.ParentID); err != nil {
+			return err
+		}
+	} else {
+		// a token outside any fork (restarted by a return) passes alone
+		cont = tok
+	}
+
+	if err := recordEvent(e.tx, InstanceEvent{InstanceID: e.inst.ID, Type: "joined", NodeID: joinID,
+		Payload: map[string]any{"joinMode": joinModeOf(n)}}, e.now); err != nil {
+		return err
+	}
+	next, found, err := e.s.findEdgeByCondition(e.tx, e.schema, e.inst, joinID, "")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("no outgoing edge at join " + joinID)
+	}
+	if err := e.setToken(cont.ID, joinID, "ACTIVE"); err != nil {
+		return err
+	}
+	return e.moveHops(cont, next, hops+1)
+}
+
+func joinModeOf(n Node) string {
+	if n.JoinMode == "" {
+		return "all"
+	}
+	return n.JoinMode
+}
+
+// cancelToken stops a branch and auto-closes its open task groups.
+func (e *engine) cancelToken(id string) error {
+	if _, err := e.tx.Exec(`UPDATE instance_tokens SET status='CANCELLED', closed_at=? WHERE id=?`, e.now, id); err != nil {
+		return err
+	}
+	return e.closeGroups(id)
+}
+
+// closeGroups closes the token's open task groups, auto-closing their
+// pending tasks.
+func (e *engine) closeGroups(id string) error {
+	groups, err := queryStrings(e.tx, `SELECT id FROM task_groups WHERE token_id=? AND status='OPEN'`, id)
+	if err != nil {
+		return err
+	}
+	for _, g := range groups {
+		if _, err := e.tx.Exec(`UPDATE task_groups SET status='CLOSED', closed_at=?, row_version=row_version+1 WHERE id=?`, e.now, g); err != nil {
+			return err
+		}
+		if err := closePendingTasks(e.tx, e.inst.ID, g, e.closeAs, e.now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelAll stops every branch (reject, return to start).
+func (e *engine) cancelAll() error {
+	ids, err := queryStrings(e.tx, `SELECT id FROM instance_tokens WHERE instance_id=? AND status IN ('ACTIVE','WAITING')`, e.inst.ID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := e.cancelToken(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentNode summarizes the active tokens for instances.current_node;
+// "end" once no token is active.
+func (e *engine) currentNode() (string, error) {
+	nodes, err := queryStrings(e.tx, `SELECT node_id FROM instance_tokens WHERE instance_id=? AND status='ACTIVE'
+		GROUP BY node_id ORDER BY MIN(created_at), node_id`, e.inst.ID)
+	if err != nil {
+		return "", err
+	}
+	if len(nodes) == 0 {
+		return "end", nil
+	}
+	return strings.Join(nodes, ","), nil
+}
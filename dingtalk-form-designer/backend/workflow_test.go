@@ -0,0 +1,185 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// parFlow drives one instance of a parallel form through the task endpoints:
+//
+//	start → pre → fork ┬ fin ──────────┬ join → gm → end
+//	                   └ legal → legal2 ┘
+type parFlow struct {
+	t  *testing.T
+	s  *Server
+	ts *httptest.Server
+	id string
+}
+
+func newParFlow(t *testing.T, joinMode string) *parFlow {
+	s, ts := newTestServer(t)
+	user := func(id string) []any { return []any{map[string]any{"type": "user", "id": id}} }
+	publishForm(t, s.DB, map[string]any{
+		"id": "par_form", "name": "Parallel", "version": 1,
+		"fields": []any{map[string]any{"id": "title", "type": "text", "label": "标题"}},
+		"workflow": map[string]any{
+			"nodes": []any{
+				map[string]any{"id": "start", "name": "发起"},
+				map[string]any{"id": "pre", "name": "预审"},
+				map[string]any{"id": "fork", "type": NodeParallelFork},
+				map[string]any{"id": "fin", "name": "财务"},
+				map[string]any{"id": "legal", "name": "法务"},
+				map[string]any{"id": "legal2", "name": "法务复核"},
+				map[string]any{"id": "join", "type": NodeParallelJoin, "joinMode": joinMode},
+				map[string]any{"id": "gm", "name": "总经理"},
+				map[string]any{"id": "end", "type": NodeEnd},
+			},
+			"edges": []any{
+				map[string]any{"from": "start", "to": "pre", "on": "submit", "assignees": user("a0")},
+				map[string]any{"from": "pre", "to": "fork", "on": "approve"},
+				map[string]any{"from": "fork", "to": "fin", "assignees": user("a1")},
+				map[string]any{"from": "fork", "to": "legal", "assignees": user("a2")},
+				map[string]any{"from": "legal", "to": "legal2", "on": "approve", "assignees": user("a3")},
+				map[string]any{"from": "fin", "to": "join", "on": "approve"},
+				map[string]any{"from": "legal2", "to": "join", "on": "approve"},
+				map[string]any{"from": "join", "to": "gm", "assignees": user("a4")},
+				map[string]any{"from": "gm", "to": "end", "on": "approve"},
+			},
+		},
+	})
+
+	f := &parFlow{t: t, s: s, ts: ts}
+	var draft struct{ ID string }
+	if code := call(t, ts, "u1", "/api/forms/par_form/instances", map[string]any{"data": map[string]any{"title": "t"}}, &draft); code != 200 {
+		t.Fatalf("create draft: %d", code)
+	}
+	f.id = draft.ID
+	if code := call(t, ts, "u1", "/api/instances/"+f.id+"/submit", nil, nil); code != 200 {
+		t.Fatalf("submit: %d", code)
+	}
+	return f
+}
+
+// act has the assignee of the pending task at node take body's action.
+func (f *parFlow) act(node string, body map[string]any) {
+	f.t.Helper()
+	var id, user string
+	if err := f.s.DB.QueryRow(`SELECT id, assignee_id FROM tasks WHERE instance_id=? AND node_id=? AND status='PENDING'`, f.id, node).
+		Scan(&id, &user); err != nil {
+		f.t.Fatalf("no pending task at %s: %v", node, err)
+	}
+	if code := call(f.t, f.ts, user, "/api/tasks/"+id+"/act", body, nil); code != 200 {
+		f.t.Fatalf("%v at %s: %d", body["action"], node, code)
+	}
+}
+
+func (f *parFlow) approve(nodes ...string) {
+	f.t.Helper()
+	for _, n := range nodes {
+		f.act(n, map[string]any{"action": "approve"})
+	}
+}
+
+// expect checks the instance status and its live tokens ("node:STATUS",
+// in any order) and that exactly the nodes with an active token have a
+// pending task.
+func (f *parFlow) expect(status string, tokens ...string) {
+	f.t.Helper()
+	var got string
+	if err := f.s.DB.QueryRow(`SELECT status FROM instances WHERE id=?`, f.id).Scan(&got); err != nil {
+		f.t.Fatal(err)
+	}
+	if got != status {
+		f.t.Fatalf("instance %s, want %s", got, status)
+	}
+	live, err := queryStrings(f.s.DB, `SELECT node_id||':'||status FROM instance_tokens WHERE instance_id=? AND status IN ('ACTIVE','WAITING')`, f.id)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	pending, err := queryStrings(f.s.DB, `SELECT node_id FROM tasks WHERE instance_id=? AND status='PENDING'`, f.id)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	var active []string
+	for _, tok := range tokens {
+		if node, ok := strings.CutSuffix(tok, ":ACTIVE"); ok {
+			active = append(active, node)
+		}
+	}
+	sort.Strings(live)
+	sort.Strings(tokens)
+	sort.Strings(pending)
+	sort.Strings(active)
+	if strings.Join(live, " ") != strings.Join(tokens, " ") {
+		f.t.Fatalf("tokens %v, want %v", live, tokens)
+	}
+	if strings.Join(pending, " ") != strings.Join(active, " ") {
+		f.t.Fatalf("pending tasks at %v, want %v", pending, active)
+	}
+}
+
+func TestForkJoinAll(t *testing.T) {
+	f := newParFlow(t, "all")
+	f.expect("RUNNING", "pre:ACTIVE")
+
+	f.approve("pre")
+	f.expect("RUNNING", "fin:ACTIVE", "legal:ACTIVE")
+
+	// the first branch waits at the join for the other one
+	f.approve("fin")
+	f.expect("RUNNING", "join:WAITING", "legal:ACTIVE")
+	f.approve("legal")
+	f.expect("RUNNING", "join:WAITING", "legal2:ACTIVE")
+
+	f.approve("legal2")
+	f.expect("RUNNING", "gm:ACTIVE")
+	f.approve("gm")
+	f.expect("APPROVED")
+}
+
+func TestJoinAnyCancelsSiblings(t *testing.T) {
+	f := newParFlow(t, "any")
+	f.approve("pre", "fin")
+	f.expect("RUNNING", "gm:ACTIVE")
+
+	var legal string
+	if err := f.s.DB.QueryRow(`SELECT status FROM instance_tokens WHERE instance_id=? AND node_id='legal'`, f.id).Scan(&legal); err != nil {
+		t.Fatal(err)
+	}
+	if legal != "CANCELLED" {
+		t.Fatalf("legal token %s, want CANCELLED", legal)
+	}
+	f.approve("gm")
+	f.expect("APPROVED")
+}
+
+func TestReturnInsideBranch(t *testing.T) {
+	f := newParFlow(t, "all")
+	f.approve("pre", "fin", "legal")
+	f.expect("RUNNING", "join:WAITING", "legal2:ACTIVE")
+
+	// only the legal branch starts over; fin keeps waiting at the join
+	f.act("legal2", map[string]any{"action": "return", "targetNodeId": "legal"})
+	f.expect("RUNNING", "join:WAITING", "legal:ACTIVE")
+
+	f.approve("legal", "legal2")
+	f.expect("RUNNING", "gm:ACTIVE")
+}
+
+func TestReturnOutsideBranch(t *testing.T) {
+	f := newParFlow(t, "all")
+	f.approve("pre", "fin")
+	f.expect("RUNNING", "join:WAITING", "legal:ACTIVE")
+
+	// a return before the fork ends every branch
+	f.act("legal", map[string]any{"action": "return", "targetNodeId": "pre"})
+	f.expect("RUNNING", "pre:ACTIVE")
+
+	// and passing the fork again starts all of them
+	f.approve("pre")
+	f.expect("RUNNING", "fin:ACTIVE", "legal:ACTIVE")
+	f.approve("fin", "legal", "legal2", "gm")
+	f.expect("APPROVED")
+}
@@ -1,6 +1,15 @@
 import type { FormSchema } from "./types";
 import { normalizeSchema } from "./utils/normalizeSchema";
 
+// The demo UI has no login screen: it relies on the backend running with
+// AUTH_DEV_MODE=1 and picks the acting user via the impersonation header.
+// Real deployments use the session cookie from POST /api/auth/login.
+const DESIGNER_USER = "u1";
+
+function asUser(userId: string, extra: Record<string, string> = {}): Record<string, string> {
+  return { "X-Impersonate-User": userId, ...extra };
+}
+
 export async function listForms(): Promise<FormSchema[]> {
   const res = await fetch("/api/forms");
   if (!res.ok) throw new Error("list forms failed");
@@ -20,7 +29,7 @@ export async function getForm(id: string): Promise<FormSchema> {
 export async function saveForm(schema: FormSchema): Promise<FormSchema> {
   const res = await fetch("/api/forms", {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers: asUser(DESIGNER_USER, { "Content-Type": "application/json" }),
     body: JSON.stringify(schema)
   });
   if (!res.ok) throw new Error("save form failed");
@@ -28,19 +37,19 @@ export async function saveForm(schema: FormSchema): Promise<FormSchema> {
 }
 
 export async function inboxTasks(userId: string) {
-  const res = await fetch(`/api/tasks/inbox?userId=${encodeURIComponent(userId)}`);
+  const res = await fetch(`/api/tasks/inbox`, { headers: asUser(userId) });
   if (!res.ok) throw new Error("inbox failed");
   return res.json();
 }
 
 export async function doneTasks(userId: string) {
-  const res = await fetch(`/api/tasks/done?userId=${encodeURIComponent(userId)}`);
+  const res = await fetch(`/api/tasks/done`, { headers: asUser(userId) });
   if (!res.ok) throw new Error("done tasks failed");
   return res.json();
 }
 
-export async function taskDetail(taskId: string) {
-  const res = await fetch(`/api/tasks/${taskId}`);
+export async function taskDetail(taskId: string, userId: string) {
+  const res = await fetch(`/api/tasks/${taskId}`, { headers: asUser(userId) });
   if (!res.ok) throw new Error("task detail failed");
   return res.json();
 }
@@ -48,7 +57,7 @@ export async function taskDetail(taskId: string) {
 export async function actTask(taskId: string, body: any) {
   const res = await fetch(`/api/tasks/${taskId}/act`, {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers: asUser(body.userId, { "Content-Type": "application/json" }),
     body: JSON.stringify(body)
   });
   if (!res.ok) throw new Error(await res.text());
@@ -58,17 +67,23 @@ export async function actTask(taskId: string, body: any) {
 export async function createInstanceDraft(formId: string, body: any) {
   const res = await fetch(`/api/forms/${formId}/instances`, {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers: asUser(body.userId, { "Content-Type": "application/json" }),
     body: JSON.stringify(body)
   });
   if (!res.ok) throw new Error("create instance failed");
   return res.json();
 }
 
+export async function getInstance(instanceId: string, userId: string) {
+  const res = await fetch(`/api/instances/${instanceId}`, { headers: asUser(userId) });
+  if (!res.ok) throw new Error("get instance failed");
+  return res.json();
+}
+
 export async function updateInstanceData(instanceId: string, body: any) {
   const res = await fetch(`/api/instances/${instanceId}/data`, {
     method: "PUT",
-    headers: { "Content-Type": "application/json" },
+    headers: asUser(body.userId, { "Content-Type": "application/json" }),
     body: JSON.stringify(body)
   });
   if (!res.ok) throw new Error(await res.text());
@@ -78,7 +93,17 @@ export async function updateInstanceData(instanceId: string, body: any) {
 export async function submitInstance(instanceId: string, body: any) {
   const res = await fetch(`/api/instances/${instanceId}/submit`, {
     method: "POST",
-    headers: { "Content-Type": "application/json" },
+    headers: asUser(body.userId, { "Content-Type": "application/json" }),
+    body: JSON.stringify(body)
+  });
+  if (!res.ok) throw new Error(await res.text());
+  return res.json();
+}
+
+export async function withdrawInstance(instanceId: string, body: any) {
+  const res = await fetch(`/api/instances/${instanceId}/withdraw`, {
+    method: "POST",
+    headers: asUser(body.userId, { "Content-Type": "application/json" }),
     body: JSON.stringify(body)
   });
   if (!res.ok) throw new Error(await res.text());
@@ -86,7 +111,16 @@ export async function submitInstance(instanceId: string, body: any) {
 }
 
 export async function listInstances(userId: string, status: "DRAFT"|"RUNNING"|"DONE") {
-  const res = await fetch(`/api/instances?userId=${encodeURIComponent(userId)}&scope=applicant&status=${status}`);
+  const res = await fetch(`/api/instances?scope=applicant&status=${status}`, { headers: asUser(userId) });
   if (!res.ok) throw new Error("list instances failed");
   return res.json();
 }
+
+export type OrgSearchHit = { type: "user" | "dept" | "role"; id: string; name: string };
+
+export async function searchOrg(q: string, type: "user" | "dept" | "role", userId: string): Promise<OrgSearchHit[]> {
+  const params = new URLSearchParams({ q, type });
+  const res = await fetch(`/api/org/search?${params}`, { headers: asUser(userId) });
+  if (!res.ok) throw new Error("search org failed");
+  return res.json();
+}
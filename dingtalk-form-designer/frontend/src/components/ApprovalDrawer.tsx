@@ -26,11 +26,13 @@ export default function ApprovalDrawer({ open, taskId, userId, onClose, onDone }
   const [data, setData] = useState<any>({});
   const [initialData, setInitialData] = useState<any>({});
   const [comment, setComment] = useState("");
+  const [returnTo, setReturnTo] = useState(""); // "" = the workflow's return edge
+  const [backToMe, setBackToMe] = useState(false);
 
   useEffect(() => {
     if (!open || !taskId) return;
     setLoading(true);
-    taskDetail(taskId)
+    taskDetail(taskId, userId)
       .then((res) => {
         setTask(res.task);
         setInstance(res.instance);
@@ -88,9 +90,49 @@ export default function ApprovalDrawer({ open, taskId, userId, onClose, onDone }
     return patch;
   }
 
+  // add-signers (task.kind set) can only approve or reject
+  const canTransfer = !task?.kind && !schema?.workflow.nodes.find((n) => n.id === nodeId)?.disallowTransfer;
+
+  async function doTransfer() {
+    if (!taskId) return;
+    const to = window.prompt("转交给（用户ID）")?.trim();
+    if (!to) return;
+
+    setLoading(true);
+    try {
+      await actTask(taskId, { userId, action: "transfer", transferTo: to, comment });
+      onDone();
+      onClose();
+    } catch (e: any) {
+      alert(e?.message || String(e));
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  async function doAddSign(action: "add_sign_before" | "add_sign_after") {
+    if (!taskId) return;
+    const input = window.prompt(action === "add_sign_before" ? "前加签：先请谁审批（用户ID，逗号分隔）" : "后加签：通过后还需谁审批（用户ID，逗号分隔）");
+    const signers = (input || "").split(/[,，\s]+/).filter(Boolean);
+    if (signers.length === 0) return;
+    const patch = action === "add_sign_after" ? buildPatch() : {};
+
+    setLoading(true);
+    try {
+      await actTask(taskId, { userId, action, signers, comment, dataPatch: patch });
+      onDone();
+      onClose();
+    } catch (e: any) {
+      alert(e?.message || String(e));
+    } finally {
+      setLoading(false);
+    }
+  }
+
   async function doAct(action: "approve" | "reject" | "return") {
     if (!taskId) return;
     const patch = buildPatch();
+    const ret = action === "return" ? { targetNodeId: returnTo || undefined, backToMe } : {};
 
     setLoading(true);
     try {
@@ -98,7 +140,8 @@ export default function ApprovalDrawer({ open, taskId, userId, onClose, onDone }
         userId,
         action,
         comment,
-        dataPatch: patch
+        dataPatch: patch,
+        ...ret
       });
       onDone();
       onClose();
@@ -167,6 +210,7 @@ export default function ApprovalDrawer({ open, taskId, userId, onClose, onDone }
                         field={field as any}
                         value={computedData[field.id]}
                         readonly={st.readonly}
+                        userId={userId}
                         onChange={(v) => setField(field.id, v)}
                       />
                     </div>
@@ -186,10 +230,28 @@ export default function ApprovalDrawer({ open, taskId, userId, onClose, onDone }
               />
             </div>
 
+            {!task?.kind ? (
+              <div style={{ display: "flex", gap: 8, marginTop: 12, alignItems: "center", fontSize: 12 }}>
+                <span>退回到</span>
+                <select value={returnTo} onChange={(e) => setReturnTo(e.target.value)}>
+                  <option value="">按流程（默认发起人）</option>
+                  {(task?.returnTargets || []).map((id: string) => (
+                    <option key={id} value={id}>{schema?.workflow.nodes.find((n) => n.id === id)?.name || id}</option>
+                  ))}
+                </select>
+                <label>
+                  <input type="checkbox" checked={backToMe} onChange={(e) => setBackToMe(e.target.checked)} /> 重新审批后直接返回我
+                </label>
+              </div>
+            ) : null}
+
             <div style={{ display: "flex", gap: 8, marginTop: 12 }}>
               <button className="btn btn-primary" disabled={loading} onClick={() => doAct("approve")}>通过</button>
               <button className="btn btn-danger" disabled={loading} onClick={() => doAct("reject")}>驳回</button>
-              <button className="btn btn-outline btn-outline-primary" disabled={loading} onClick={() => doAct("return")}>退回发起人</button>
+              {!task?.kind && <button className="btn btn-outline btn-outline-primary" disabled={loading} onClick={() => doAct("return")}>退回</button>}
+              {canTransfer && <button className="btn btn-outline btn-outline-primary" disabled={loading} onClick={doTransfer}>转交</button>}
+              {!task?.kind && <button className="btn btn-outline btn-outline-primary" disabled={loading} onClick={() => doAddSign("add_sign_before")}>前加签</button>}
+              {!task?.kind && <button className="btn btn-outline btn-outline-primary" disabled={loading} onClick={() => doAddSign("add_sign_after")}>后加签</button>}
             </div>
 
 
@@ -1,5 +1,5 @@
 import React, { useEffect, useMemo, useState } from "react";
-import { createInstanceDraft, listInstances, submitInstance, updateInstanceData } from "../api";
+import { createInstanceDraft, getInstance, listInstances, submitInstance, updateInstanceData, withdrawInstance } from "../api";
 import type { FormSchema, Field } from "../types";
 import { applyCalculations, computeFieldState } from "../runtime/renderEngine";
 import { FieldInput } from "../runtime/fields";
@@ -53,9 +53,12 @@ export default function MyApplicantPanel({ currentForm }: { currentForm: FormSch
   }
 
   async function openEditor(instanceId: string) {
-    const res = await fetch(`/api/instances/${instanceId}`);
-    if (!res.ok) return alert("加载实例失败");
-    const inst = await res.json();
+    let inst: any;
+    try {
+      inst = await getInstance(instanceId, userId);
+    } catch {
+      return alert("加载实例失败");
+    }
     setEditingInstanceId(instanceId);
     setEditingData(inst.data || {});
     setInitialData(inst.data || {});
@@ -80,8 +83,11 @@ export default function MyApplicantPanel({ currentForm }: { currentForm: FormSch
     if (!editingInstanceId) return;
     if (!currentForm) return;
 
+    // calculated fields are recomputed by the backend and may not be written
+    const calculated = new Set((currentForm.calculations || []).map(c => c.targetFieldId));
     const patch: Record<string, any> = {};
     for (const f of fields) {
+      if (calculated.has(f.id)) continue;
       const a = initialData?.[f.id];
       const b = computedData?.[f.id];
       if (JSON.stringify(a ?? null) !== JSON.stringify(b ?? null)) patch[f.id] = b;
@@ -113,6 +119,20 @@ export default function MyApplicantPanel({ currentForm }: { currentForm: FormSch
     }
   }
 
+  async function withdrawThisInstance(instanceId: string) {
+    if (!confirm("确定撤回该申请？")) return;
+    setLoading(true);
+    try {
+      await withdrawInstance(instanceId, { userId });
+      alert("已撤回");
+      await refresh();
+    } catch (e: any) {
+      alert(e?.message || String(e));
+    } finally {
+      setLoading(false);
+    }
+  }
+
   return (
     <div className="card">
       <div className="card-header">
@@ -139,6 +159,7 @@ export default function MyApplicantPanel({ currentForm }: { currentForm: FormSch
             <div style={{ display: "flex", gap: 8, marginTop: 8 }}>
               {tab !== "DONE" ? <button className="btn btn-outline btn-sm" onClick={() => openEditor(x.id)}>编辑</button> : null}
               {tab === "DRAFT" ? <button className="btn btn-primary btn-sm" onClick={() => submitThisInstance(x.id)}>提交</button> : null}
+              {tab === "RUNNING" ? <button className="btn btn-outline btn-sm" onClick={() => withdrawThisInstance(x.id)}>撤回</button> : null}
 
             </div>
           </div>
@@ -198,6 +219,7 @@ export default function MyApplicantPanel({ currentForm }: { currentForm: FormSch
                         field={field as any}
                         value={computedData[field.id]}
                         readonly={st.readonly}
+                        userId={userId}
                         onChange={(v) => setEditingData((prev: any) => ({ ...prev, [field.id]: v }))}
                       />
                     </div>
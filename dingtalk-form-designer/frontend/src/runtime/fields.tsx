@@ -1,15 +1,61 @@
-import React from "react";
+import React, { useEffect, useId, useState } from "react";
 import type { Field } from "../types";
+import { searchOrg, type OrgSearchHit } from "../api";
+
+// OrgPicker is a text input that suggests users/departments from
+// /api/org/search as userId; the stored value is the picked id. Without a
+// user (designer preview) it is a plain input.
+function OrgPicker({
+  type,
+  value,
+  readonly,
+  placeholder,
+  userId,
+  onChange
+}: {
+  type: "user" | "dept";
+  value: any;
+  readonly: boolean;
+  placeholder: string;
+  userId?: string;
+  onChange: (v: any) => void;
+}) {
+  const listId = useId();
+  const [hits, setHits] = useState<OrgSearchHit[]>([]);
+  const text = value ?? "";
+
+  useEffect(() => {
+    if (readonly || !userId) return;
+    let cancelled = false;
+    const t = setTimeout(() => {
+      searchOrg(text, type, userId)
+        .then((r) => { if (!cancelled) setHits(r); })
+        .catch(() => { if (!cancelled) setHits([]); });
+    }, 200);
+    return () => { cancelled = true; clearTimeout(t); };
+  }, [text, type, readonly, userId]);
+
+  return (
+    <>
+      <input disabled={readonly} list={listId} placeholder={placeholder} value={text} onChange={(e) => onChange(e.target.value)} />
+      <datalist id={listId}>
+        {hits.map((h) => <option key={h.id} value={h.id}>{h.name}</option>)}
+      </datalist>
+    </>
+  );
+}
 
 export function FieldInput({
   field,
   value,
   readonly,
+  userId,
   onChange
 }: {
   field: Field;
   value: any;
   readonly: boolean;
+  userId?: string;
   onChange: (v: any) => void;
 }) {
   const common = { disabled: readonly };
@@ -43,9 +89,9 @@ export function FieldInput({
     case "attachment":
       return <input {...common} placeholder="Demo：用 URL 代替附件" value={value ?? ""} onChange={(e) => onChange(e.target.value)} />;
     case "department":
-      return <input {...common} placeholder="Demo：部门ID/名称" value={value ?? ""} onChange={(e) => onChange(e.target.value)} />;
+      return <OrgPicker type="dept" value={value} readonly={readonly} placeholder="搜索部门" userId={userId} onChange={onChange} />;
     case "member":
-      return <input {...common} placeholder="Demo：成员ID/名称" value={value ?? ""} onChange={(e) => onChange(e.target.value)} />;
+      return <OrgPicker type="user" value={value} readonly={readonly} placeholder="搜索成员" userId={userId} onChange={onChange} />;
     case "subtable": {
       const rows: any[] = Array.isArray(value) ? value : [];
       const columns = (field as any).columns as Field[];
@@ -80,6 +126,7 @@ export function FieldInput({
                         field={col}
                         value={(row as any)[col.id]}
                         readonly={readonly}
+                        userId={userId}
                         onChange={(v) => {
                           const next = rows.slice();
                           next[idx] = { ...row, [col.id]: v };
@@ -36,7 +36,7 @@ export function computeFieldState(schema: FormSchema, field: Field, formData: an
       const data = { form: formData, ctx };
       visible = !!jsonLogic.apply(field.visibleWhen, data);
     } catch {
-      visible = true;
+      visible = false;
     }
   }
 
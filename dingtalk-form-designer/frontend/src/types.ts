@@ -27,7 +27,17 @@ export type Field = FieldBase | SelectField | NumberField | SubtableField;
 
 export type Calc = { targetFieldId: string; expr: string };
 
-export type Node = { id: string; name: string };
+export type NodeType = "task" | "parallel_fork" | "parallel_join" | "exclusive" | "end";
+
+export type Node = {
+  id: string;
+  name: string;
+  type?: NodeType;
+  joinMode?: "all" | "any";
+  disallowTransfer?: boolean;
+  onEmptyAssignee?: "block" | "auto_pass" | "admin";
+  adminUserId?: string;
+};
 
 export type NodePolicy = {
   visible: string[];
@@ -44,6 +54,8 @@ export type FormSchema = {
   workflow: {
     nodes: Node[];
     edges?: any[];
+    withdraw?: { when?: "never" | "before_approval" | "before_end"; to?: "draft" | "withdrawn" };
+    autoApprove?: { applicant?: boolean; repeated?: "adjacent" | "any" };
     policies: Record<string, NodePolicy>;
   };
 };